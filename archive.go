@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"time"
+)
+
+// archive is a gzip-compressed tar file that -split subnets are written
+// into as entries instead of loose files. Tar headers need the entry size
+// before its content, so each entry is rendered into buf first.
+type archive struct {
+	path    string       // Final path of the archive
+	tmp     string       // Temp file renamed to path on close
+	file    *os.File     // Open temp file
+	gz      *gzip.Writer // Compressor over file
+	tw      *tar.Writer  // Tar stream over gz
+	buf     bytes.Buffer // Content of the entry being written
+	entries int          // Entries added so far
+	modTime time.Time    // Timestamp given to every entry
+	closed  bool         // Set once close has run
+}
+
+// openArchive creates the archive at path under a temp name, like a fresh
+// output file
+func openArchive(path string) (*archive, error) {
+	a := &archive{path: path, tmp: path + ".tmp", modTime: time.Now()}
+	var err error
+	a.file, err = os.Create(a.tmp)
+	if err != nil {
+		return nil, fmt.Errorf("error creating archive: %v", err)
+	}
+	a.gz = gzip.NewWriter(a.file)
+	a.tw = tar.NewWriter(a.gz)
+	return a, nil
+}
+
+// add writes the buffered content as an entry called name and empties the
+// buffer for the next one
+func (a *archive) add(name string) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(a.buf.Len()),
+		ModTime: a.modTime,
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return writeError(err)
+	}
+	if _, err := a.tw.Write(a.buf.Bytes()); err != nil {
+		return writeError(err)
+	}
+	a.buf.Reset()
+	a.entries++
+	return nil
+}
+
+// close finishes the tar stream, then the gzip stream and then the file,
+// in that order so neither trailer is lost, and renames the archive into
+// place. Calling it again is a no-op.
+func (a *archive) close() error {
+	if a.closed {
+		return nil
+	}
+	a.closed = true
+
+	err := a.tw.Close()
+	if gzErr := a.gz.Close(); err == nil {
+		err = gzErr
+	}
+	if fileErr := a.file.Close(); err == nil {
+		err = fileErr
+	}
+	if err != nil {
+		os.Remove(a.tmp)
+		return writeError(err)
+	}
+	if err := os.Rename(a.tmp, a.path); err != nil {
+		os.Remove(a.tmp)
+		return fmt.Errorf("error renaming archive: %v", err)
+	}
+	return nil
+}
+
+// discard abandons an archive that failed part way, removing its temp
+// file. It is a no-op after close, so it can be deferred for error paths.
+func (a *archive) discard() {
+	if a.closed {
+		return
+	}
+	a.closed = true
+	a.file.Close()
+	os.Remove(a.tmp)
+}
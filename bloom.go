@@ -0,0 +1,48 @@
+package main
+
+import (
+	"hash/fnv"
+	"math"
+)
+
+// bloom is a fixed-size Bloom filter over address keys for -dedupe-approx.
+// It answers "written before?" in a few bits per address however many
+// there are, at the cost of sometimes saying yes for a new address.
+type bloom struct {
+	bits []uint64 // Bit array, m bits rounded up to whole words
+	m    uint64   // Number of bits
+	k    int      // Number of bit positions set per key
+}
+
+// newBloom returns a filter sized so that after n keys a new key is
+// mistaken for a seen one with probability about rate
+func newBloom(n uint64, rate float64) *bloom {
+	n = max(n, 1)
+	m := uint64(math.Ceil(-float64(n) * math.Log(rate) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	k := int(math.Round(float64(m) / float64(n) * math.Ln2))
+	k = max(k, 1)
+	return &bloom{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// add records key and reports whether it was possibly added before. A
+// false answer is always right; a true one is wrong at the filter's rate.
+func (b *bloom) add(key []byte) bool {
+	// Derive the k positions from two halves of one hash (Kirsch and
+	// Mitzenmacher), which is as good as k independent hashes
+	h := fnv.New64a()
+	h.Write(key)
+	sum := h.Sum64()
+	h1, h2 := sum&0xffffffff, sum>>32|1
+
+	seen := true
+	for i := 0; i < b.k; i++ {
+		pos := (h1 + uint64(i)*h2) % b.m
+		word, bit := pos/64, uint64(1)<<(pos%64)
+		if b.bits[word]&bit == 0 {
+			seen = false
+			b.bits[word] |= bit
+		}
+	}
+	return seen
+}
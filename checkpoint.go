@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"time"
+)
+
+// checkpointInterval is the least time between two checkpoint saves, so a
+// fast run isn't slowed down by rewriting the file all the time
+const checkpointInterval = time.Second
+
+// checkpoint is how far a -checkpoint run got, saved so that an interrupted
+// run can pick up where it stopped. Bytes is the size of the output when
+// the checkpoint was saved: anything after it was written after the save,
+// and is cut off and written again on resume so that nothing is doubled.
+type checkpoint struct {
+	Inputs    []string  `json:"inputs"`
+	Format    string    `json:"format"`
+	Output    string    `json:"output_file"`
+	LastIP    string    `json:"last_ip,omitempty"`
+	Next      uint64    `json:"next_offset"`
+	Count     int       `json:"count"`
+	Bytes     int64     `json:"bytes"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// checkpointer saves the progress of a generator to a checkpoint file
+type checkpointer struct {
+	path   string     // Checkpoint file
+	state  checkpoint // Last saved state, or the resumed one before the first save
+	bases  []uint64   // Offset of the first address of each target
+	prior  int        // Addresses written by earlier runs
+	saved  time.Time  // When the state was last saved
+	last   net.IP     // Last address written by this run, nil before the first
+	target int        // Target the last address came from
+}
+
+// targetInputs returns the targets as a checkpoint records them, to tell
+// whether a checkpoint belongs to the same job
+func targetInputs(targets []target) []string {
+	inputs := make([]string, len(targets))
+	for i, t := range targets {
+		inputs[i] = t.String()
+	}
+	return inputs
+}
+
+// loadCheckpoint reads the checkpoint at path, returning nil if there is
+// none yet because the job hasn't been started
+func loadCheckpoint(path string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint: %v", err)
+	}
+	var c checkpoint
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+// matches returns an error unless the checkpoint was saved by a run over
+// the same targets in the same format, so two jobs are never mixed in one
+// file
+func (c *checkpoint) matches(path string, config *Config, targets []target) error {
+	if inputs := targetInputs(targets); !slices.Equal(c.Inputs, inputs) {
+		return fmt.Errorf("checkpoint %s is for %v, not %v; remove it to start over", path, c.Inputs, inputs)
+	}
+	if c.Format != config.format {
+		return fmt.Errorf("checkpoint %s is for -format %s, not %s; remove it to start over", path, c.Format, config.format)
+	}
+	return nil
+}
+
+// newCheckpointer returns a checkpointer for a run over targets writing to
+// output, carrying on from resumed if it is not nil
+func newCheckpointer(path string, targets []target, output string, format string, resumed *checkpoint) *checkpointer {
+	c := &checkpointer{path: path, bases: make([]uint64, len(targets)), saved: time.Now()}
+	next := uint64(0)
+	for i, t := range targets {
+		c.bases[i] = next
+		next += t.span.Size().Uint64()
+	}
+	if resumed != nil {
+		c.state = *resumed
+		c.prior = resumed.Count
+	} else {
+		c.state = checkpoint{Inputs: targetInputs(targets), Format: format, Output: output}
+	}
+	return c
+}
+
+// written records ip, of target i, as the last address written, saving a
+// checkpoint now and then
+func (g *generator) written(ip net.IP, i int) error {
+	c := g.checkpoint
+	c.last, c.target = ip, i
+	if g.tally.written%progressInterval != 0 || time.Since(c.saved) < checkpointInterval {
+		return nil
+	}
+	// The buffered addresses go out first, so the saved size covers them
+	if err := g.writer.Flush(); err != nil {
+		return writeError(err)
+	}
+	return g.saveCheckpoint()
+}
+
+// saveCheckpoint writes the checkpoint for what has reached the output so
+// far. The caller flushes the output first.
+func (g *generator) saveCheckpoint() error {
+	c := g.checkpoint
+	info, err := os.Stat(c.state.Output)
+	if err != nil {
+		return fmt.Errorf("error saving checkpoint: %v", err)
+	}
+	if c.last != nil {
+		c.state.LastIP = c.last.String()
+		c.state.Next = c.bases[c.target] + offsetOf(g.targets[c.target].span, c.last) + 1
+	}
+	c.state.Count = c.prior + g.tally.written
+	c.state.Bytes = info.Size()
+	c.state.UpdatedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(c.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error saving checkpoint: %v", err)
+	}
+	if err := replaceFile(c.path, append(data, '\n')); err != nil {
+		return fmt.Errorf("error saving checkpoint: %v", err)
+	}
+	c.saved = time.Now()
+	return nil
+}
+
+// resumeOutput cuts the output file named by the checkpoint back to the
+// size it had when the checkpoint was saved
+func (c *checkpoint) resumeOutput() error {
+	info, err := os.Stat(c.Output)
+	if err != nil {
+		return fmt.Errorf("can't resume %s: %v", c.Output, err)
+	}
+	if info.Size() < c.Bytes {
+		return fmt.Errorf("can't resume %s: it is shorter than when the checkpoint was saved, so it was changed since", c.Output)
+	}
+	if err := os.Truncate(c.Output, c.Bytes); err != nil {
+		return fmt.Errorf("can't resume %s: %v", c.Output, err)
+	}
+	return nil
+}
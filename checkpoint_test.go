@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+func TestCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	state := filepath.Join(dir, "job.checkpoint")
+	output := filepath.Join(dir, "job.txt")
+	args := []string{"-cidr", "10.0.0.0/11", "-force", "-yes", "-no-progress", "-output", dir, "-filename", "job", "-checkpoint", state, "-checksum"}
+	var want bytes.Buffer
+	if _, err := iplist.GenerateIPs("10.0.0.0/11", &want); err != nil {
+		t.Fatal(err)
+	}
+
+	// A time limit stops the run part way, as an interrupt would, saving
+	// how far it got
+	summary, _, err := run(t, append(args, "-timeout", "20ms")...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := loadCheckpoint(state)
+	if err != nil || saved == nil {
+		t.Fatalf("no checkpoint after the interrupted run (%v):\n%s", err, summary)
+	}
+	partial, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Count == 0 || int64(len(partial)) != saved.Bytes || !bytes.HasPrefix(want.Bytes(), partial) {
+		t.Fatalf("checkpoint of %d IPs and %d bytes doesn't match the %d-byte partial output", saved.Count, saved.Bytes, len(partial))
+	}
+
+	// Output written after the last save, as by a run killed outright, is
+	// cut off and written again
+	f, err := os.OpenFile(output, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("10.31.255.255\n10.0.0.")
+	f.Close()
+
+	summary, _, err = run(t, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(summary, "Resumed") {
+		t.Errorf("summary doesn't mention resuming:\n%s", summary)
+	}
+	got, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want.Bytes()) {
+		t.Errorf("resumed output of %d bytes differs from a single run of %d", len(got), want.Len())
+	}
+	if _, err := os.Stat(state); !os.IsNotExist(err) {
+		t.Errorf("checkpoint left behind after the job finished: %v", err)
+	}
+
+	// The sidecar covers the whole file, not just the part written after
+	// resuming
+	sidecar, err := os.ReadFile(output + ".sha256")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(got)
+	if want := hex.EncodeToString(sum[:]) + "  job.txt\n"; string(sidecar) != want {
+		t.Errorf("sidecar %q, want %q", sidecar, want)
+	}
+}
+
+func TestCheckpointMismatch(t *testing.T) {
+	dir := t.TempDir()
+	state := filepath.Join(dir, "job.checkpoint")
+	if err := os.WriteFile(state, []byte(`{"inputs": ["10.0.0.0/24"], "format": "txt", "output_file": "job.txt"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, args := range [][]string{
+		{"-cidr", "10.0.1.0/24"},
+		{"-cidr", "10.0.0.0/24", "-format", "int"},
+	} {
+		_, _, err := run(t, append(args, "-output", dir, "-filename", "job", "-checkpoint", state)...)
+		if err == nil || !strings.Contains(err.Error(), "remove it to start over") {
+			t.Errorf("%v: got %v, want a mismatch error", args, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// applyConfigFile sets the flags named in the JSON object at path, such as
+// {"format": "csv", "exclude": "10.0.0.0/28", "limit": 500}, unless they
+// were already set on the command line or by applyEnv. Values go through
+// each flag's own parsing, and an array sets a repeatable flag once per
+// element.
+func applyConfigFile(path string, fs *flag.FlagSet) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config file: %v", err)
+	}
+
+	// Keep numbers as written so large integers aren't rounded
+	var settings map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&settings); err != nil {
+		return fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	// Apply in name order so the first bad setting reported is stable
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if name == "config" || fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown setting %q in config file %s", name, path)
+		}
+		if explicit[name] {
+			continue
+		}
+
+		values, ok := settings[name].([]any)
+		if !ok {
+			values = []any{settings[name]}
+		}
+		for _, v := range values {
+			switch v.(type) {
+			case string, bool, json.Number:
+			default:
+				return fmt.Errorf("invalid %s in config file %s: expected a string, number or boolean", name, path)
+			}
+			if err := fs.Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("invalid %s in config file %s: %v", name, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// envSettings maps the flags that can come from the environment to their
+// variables, for containers that configure a run without arguments
+var envSettings = []struct {
+	flag, env string
+}{
+	{"cidr", "IP_LIST_CIDR"},
+	{"output", "IP_LIST_OUTPUT"},
+	{"filename", "IP_LIST_FILENAME"},
+}
+
+// applyEnv sets each flag in envSettings from its environment variable
+// when the variable is non-empty and the flag wasn't given on the command
+// line. It runs before applyConfigFile, which leaves flags already set
+// alone, so precedence is defaults, then the config file, then the
+// environment, then explicit flags.
+func applyEnv(fs *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	for _, s := range envSettings {
+		value := os.Getenv(s.env)
+		if value == "" || explicit[s.flag] {
+			continue
+		}
+		if err := fs.Set(s.flag, value); err != nil {
+			return fmt.Errorf("invalid %s: %v", s.env, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeConfig writes settings to a config file and returns its path
+func writeConfig(t *testing.T, settings string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(settings), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFile(t *testing.T) {
+	path := writeConfig(t, `{
+		"cidr": "10.0.0.0/24",
+		"format": "csv",
+		"exclude": "10.0.0.0/28",
+		"limit": 500,
+		"usable": true,
+		"post-header": ["X-One: 1", "X-Two: 2"]
+	}`)
+	config := testConfig(t, "-config", path)
+	if config.cidr != "10.0.0.0/24" || config.format != "csv" || config.exclude != "10.0.0.0/28" || config.limit != 500 || !config.usable {
+		t.Errorf("settings not applied: cidr %q, format %q, exclude %q, limit %d, usable %v", config.cidr, config.format, config.exclude, config.limit, config.usable)
+	}
+	if want := []string{"X-One: 1", "X-Two: 2"}; !slices.Equal(config.postHeader, want) {
+		t.Errorf("post-header: got %q, want %q", config.postHeader, want)
+	}
+
+	// Flags on the command line take precedence over the file
+	config = testConfig(t, "-config", path, "-format", "json", "-limit", "7")
+	if config.format != "json" || config.limit != 7 || config.exclude != "10.0.0.0/28" {
+		t.Errorf("flags didn't override: format %q, limit %d, exclude %q", config.format, config.limit, config.exclude)
+	}
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	for _, settings := range []string{
+		`{"no-such-flag": 1}`,
+		`{"config": "other.json"}`,
+		`{"limit": "many"}`,
+		`{"cidr": {"nested": true}}`,
+		`not json`,
+	} {
+		if _, err := parseTestArgs("-config", writeConfig(t, settings)); err == nil {
+			t.Errorf("%s: expected an error", settings)
+		}
+	}
+	if _, err := parseTestArgs("-config", filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+}
+
+func TestEnv(t *testing.T) {
+	t.Setenv("IP_LIST_CIDR", "10.0.0.0/30")
+	t.Setenv("IP_LIST_OUTPUT", "/tmp/lists")
+	t.Setenv("IP_LIST_FILENAME", "from-env")
+	config := testConfig(t)
+	if config.cidr != "10.0.0.0/30" || config.outputDir != "/tmp/lists" || config.filename != "from-env" {
+		t.Errorf("env not applied: cidr %q, output %q, filename %q", config.cidr, config.outputDir, config.filename)
+	}
+
+	// Flags take precedence over the environment, and the environment
+	// over the config file
+	path := writeConfig(t, `{"cidr": "192.168.0.0/24", "filename": "from-file", "format": "csv"}`)
+	config = testConfig(t, "-config", path, "-cidr", "10.1.0.0/24")
+	if config.cidr != "10.1.0.0/24" || config.filename != "from-env" || config.format != "csv" {
+		t.Errorf("precedence: cidr %q, filename %q, format %q", config.cidr, config.filename, config.format)
+	}
+
+	// An empty variable counts as unset
+	t.Setenv("IP_LIST_FILENAME", "")
+	if config := testConfig(t, "-config", path); config.filename != "from-file" {
+		t.Errorf("filename %q, want the config file's", config.filename)
+	}
+}
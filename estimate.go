@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// estimateSamples is how many addresses of each target are rendered to
+// measure the average record length
+const estimateSamples = 256
+
+// byteCounter is an io.Writer that only counts what is written to it
+type byteCounter struct {
+	n uint64 // Bytes written so far
+}
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	c.n += uint64(len(p))
+	return len(p), nil
+}
+
+// estimateSize returns the approximate size in bytes of writing planned
+// of the total addresses visited in spans, visits[k] of them in spans[k].
+// Randomly chosen addresses from each span are rendered with the
+// configured formatter, so the average record length reflects both the
+// format and how long the addresses are. Compression and skipped
+// addresses are not accounted for. A run that writes nothing is only as big
+// as its framing.
+func estimateSize(config *Config, spans []iplist.Range, visits []uint64, planned, total uint64) uint64 {
+	// Measure -resolve as plain lines rather than looking up hostnames
+	measured := *config
+	measured.resolve = false
+
+	// A fixed seed keeps the estimate the same from run to run
+	rng := rand.New(rand.NewSource(1))
+	counter := &byteCounter{}
+	w := bufio.NewWriter(counter)
+	var targets []target
+	for _, span := range spans {
+		if span.First != nil {
+			targets = append(targets, target{span: span})
+		}
+	}
+	f := newFormatter(&measured, targets)
+
+	// The counter never fails, so neither can the formatter
+	f.begin(w)
+	w.Flush()
+	framing := counter.n
+
+	// A run that writes nothing, such as a -last-octet that matches no
+	// address, is only its framing
+	sampled := planned > 0 && total > 0
+	records := 0.0
+	for k, span := range spans {
+		if span.First == nil || !sampled {
+			continue
+		}
+		size := span.Size().Uint64()
+		n := min(size, estimateSamples)
+		before := counter.n
+		for j := uint64(0); j < n; j++ {
+			// CSV rows carry their position, so give each sampled row one
+			// from anywhere in the run
+			if csv, ok := f.(*csvFormatter); ok {
+				csv.index = int(rng.Int63n(int64(planned)))
+			}
+			f.writeIP(w, span.At(uint64(rng.Int63n(int64(size)))))
+		}
+		w.Flush()
+		records += float64(counter.n-before) / float64(n) * float64(visits[k])
+	}
+
+	before := counter.n
+	f.end(w)
+	w.Flush()
+	framing += counter.n - before
+
+	if !sampled {
+		return framing
+	}
+	return framing + uint64(records*float64(planned)/float64(total))
+}
+
+// printEstimate writes the number of addresses a run would produce and
+// its estimated output size
+func printEstimate(w io.Writer, config *Config, spans []iplist.Range, visits []uint64, planned, total uint64) {
+	size := estimateSize(config, spans, visits, planned, total)
+	fmt.Fprintf(w, "IPs to Generate: %d\n", planned)
+	if size < 1024 {
+		fmt.Fprintf(w, "Estimated Size: %d bytes\n", size)
+	} else {
+		fmt.Fprintf(w, "Estimated Size: %d bytes (%s)\n", size, humanBytes(size))
+	}
+	if config.gzip {
+		fmt.Fprintf(w, "Note: size is before gzip compression\n")
+	}
+}
+
+// humanBytes formats n bytes with a binary unit, e.g. 1.5 GB
+func humanBytes(n uint64) string {
+	units := []string{"bytes", "KB", "MB", "GB", "TB"}
+	size := float64(n)
+	unit := 0
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %s", size, units[unit])
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// estimated runs args with -estimate and returns the planned count and
+// estimated size it prints
+func estimated(t *testing.T, args ...string) (int, int) {
+	t.Helper()
+	out := mustRun(t, append(args, "-estimate")...)
+	var count, size int
+	for _, line := range lines(out) {
+		if v, ok := strings.CutPrefix(line, "IPs to Generate: "); ok {
+			count, _ = strconv.Atoi(v)
+		}
+		if v, ok := strings.CutPrefix(line, "Estimated Size: "); ok {
+			size, _ = strconv.Atoi(strings.Fields(v)[0])
+		}
+	}
+	return count, size
+}
+
+func TestEstimateSize(t *testing.T) {
+	for _, format := range []string{"txt", "csv", "json", "hex", "binary"} {
+		t.Run(format, func(t *testing.T) {
+			args := []string{"-cidr", "10.0.0.0/24", "-format", format}
+			count, size := estimated(t, args...)
+			actual := len(mustRun(t, append(args, "-stdout")...))
+			if count != 256 {
+				t.Errorf("planned %d IPs, want 256", count)
+			}
+			if diff := size - actual; diff < -actual/20 || diff > actual/20 {
+				t.Errorf("estimated %d bytes, actual %d", size, actual)
+			}
+		})
+	}
+}
+
+func TestEstimateNoTargets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cidrs.txt")
+	if err := os.WriteFile(path, []byte("# none\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if count, size := estimated(t, "-cidr-file", path); count != 0 || size != 0 {
+		t.Errorf("got %d IPs in %d bytes, want none", count, size)
+	}
+	if count, size := estimated(t, "-cidr-file", path, "-format", "csv"); count != 0 || size != len("index,ip\n") {
+		t.Errorf("got %d IPs in %d bytes, want just the header", count, size)
+	}
+}
+
+func TestEstimateFilterMatchesNothing(t *testing.T) {
+	for _, format := range []string{"txt", "csv", "json"} {
+		args := []string{"-cidr", "10.0.0.0/30", "-last-octet", "200", "-format", format}
+		count, size := estimated(t, args...)
+		actual := len(mustRun(t, append(args, "-stdout")...))
+		if count != 0 || size != actual {
+			t.Errorf("-format %s: estimated %d IPs in %d bytes, want 0 in %d", format, count, size, actual)
+		}
+	}
+}
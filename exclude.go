@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"math/big"
+	"net"
+	"sort"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// offsetRun is a stretch of offsets into a span, lo through hi inclusive
+type offsetRun struct {
+	lo, hi uint64
+}
+
+// offsetOf returns how many addresses ip is past span.First
+func offsetOf(span iplist.Range, ip net.IP) uint64 {
+	d := new(big.Int).SetBytes(ip)
+	return d.Sub(d, new(big.Int).SetBytes(span.First)).Uint64()
+}
+
+// excludedRuns returns the stretches of span covered by excludes, in
+// ascending order with overlapping and touching ones merged
+func excludedRuns(span iplist.Range, excludes []*net.IPNet) []offsetRun {
+	var runs []offsetRun
+	for _, ex := range excludes {
+		r := iplist.NetworkRange(ex)
+		if r.IsIPv4() != span.IsIPv4() {
+			continue
+		}
+		lo, hi := r.First, r.Last
+		if !span.IsIPv4() {
+			lo, hi = lo.To16(), hi.To16()
+		}
+		if bytes.Compare(hi, span.First) < 0 || bytes.Compare(lo, span.Last) > 0 {
+			continue
+		}
+		if bytes.Compare(lo, span.First) < 0 {
+			lo = span.First
+		}
+		if bytes.Compare(hi, span.Last) > 0 {
+			hi = span.Last
+		}
+		runs = append(runs, offsetRun{offsetOf(span, lo), offsetOf(span, hi)})
+	}
+
+	sort.Slice(runs, func(a, b int) bool { return runs[a].lo < runs[b].lo })
+	merged := runs[:0]
+	for _, r := range runs {
+		if n := len(merged); n > 0 && r.lo <= merged[n-1].hi+1 {
+			merged[n-1].hi = max(merged[n-1].hi, r.hi)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+// overlapsAny reports whether any address of ipnet is in one of targets
+func overlapsAny(ipnet *net.IPNet, targets []target) bool {
+	for _, t := range targets {
+		if excludedRuns(t.span, []*net.IPNet{ipnet}) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// writeAround is writeRange for a span of target i under -exclude. The
+// excluded stretches are jumped over and counted in one go rather than
+// visited address by address, and the pieces between them are written
+// without checking each address against every exclusion, so carving a
+// /24 out of a /8 costs about as much as not excluding anything.
+func (g *generator) writeAround(span iplist.Range, i int) error {
+	runs := excludedRuns(span, g.filters.excludes)
+
+	// Lay out the span as alternating kept pieces and excluded runs, in
+	// the order they are written
+	type part struct {
+		run      offsetRun
+		excluded bool
+	}
+	var parts []part
+	next := uint64(0)
+	for _, r := range runs {
+		if r.lo > next {
+			parts = append(parts, part{run: offsetRun{next, r.lo - 1}})
+		}
+		parts = append(parts, part{run: r, excluded: true})
+		next = r.hi + 1
+	}
+	if size := span.Size().Uint64(); next < size {
+		parts = append(parts, part{run: offsetRun{next, size - 1}})
+	}
+	if g.config.reverse {
+		for a, b := 0, len(parts)-1; a < b; a, b = a+1, b-1 {
+			parts[a], parts[b] = parts[b], parts[a]
+		}
+	}
+
+	excludes := g.filters.excludes
+	g.filters.excludes = nil
+	defer func() { g.filters.excludes = excludes }()
+
+	t := g.targets[i]
+	for _, p := range parts {
+		if !p.excluded {
+			piece := iplist.Range{First: span.At(p.run.lo), Last: span.At(p.run.hi)}
+			if err := g.writeRange(piece, i); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// A network or broadcast address inside an exclusion is still
+		// counted as reserved by -usable, as admit would have
+		skipped := p.run.hi - p.run.lo + 1
+		if g.filters.usable && hasBroadcast(t) {
+			for _, end := range []net.IP{t.span.First, t.span.Last} {
+				if span.Contains(end) {
+					if o := offsetOf(span, end); o >= p.run.lo && o <= p.run.hi {
+						g.tally.reserved++
+						skipped--
+					}
+				}
+			}
+		}
+		g.tally.excluded += int(skipped)
+		g.progress.skip(p.run.hi - p.run.lo + 1)
+	}
+	return nil
+}
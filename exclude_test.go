@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+func TestExclude(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want []string
+	}{
+		{"overlapping", []string{"-exclude", "10.0.0.2/31,10.0.0.3/32"}, []string{"10.0.0.0", "10.0.0.1", "10.0.0.4", "10.0.0.5", "10.0.0.6", "10.0.0.7"}},
+		{"adjacent", []string{"-exclude", "10.0.0.0/30,10.0.0.4/31"}, []string{"10.0.0.6", "10.0.0.7"}},
+		{"past the end", []string{"-exclude", "10.0.0.6/30"}, []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3"}},
+		{"whole range", []string{"-exclude", "10.0.0.0/24"}, nil},
+		{"reverse", []string{"-exclude", "10.0.0.2/31", "-reverse"}, []string{"10.0.0.7", "10.0.0.6", "10.0.0.5", "10.0.0.4", "10.0.0.1", "10.0.0.0"}},
+		{"usable", []string{"-exclude", "10.0.0.0/30", "-usable"}, []string{"10.0.0.4", "10.0.0.5", "10.0.0.6"}},
+		{"step", []string{"-exclude", "10.0.0.2/31", "-step", "2"}, []string{"10.0.0.0", "10.0.0.4", "10.0.0.6"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mustRun(t, append([]string{"-cidr", "10.0.0.0/29", "-stdout"}, tt.args...)...)
+			equalLines(t, got, tt.want)
+		})
+	}
+}
+
+func TestExcludeOutOfRange(t *testing.T) {
+	stdout, stderr, err := run(t, "-cidr", "10.0.0.0/29", "-exclude", "10.0.1.0/30", "-stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines(stdout)) != 8 {
+		t.Errorf("got %d IPs, want all 8", len(lines(stdout)))
+	}
+	if !strings.Contains(stderr, "outside every target range") {
+		t.Errorf("no warning about the exclusion in %q", stderr)
+	}
+
+	if _, _, err := run(t, "-cidr", "10.0.0.0/29", "-exclude", "10.0.1.0/30", "-strict", "-stdout"); err == nil {
+		t.Error("expected -strict to refuse an exclusion outside the range")
+	}
+	if _, _, err := run(t, "-cidr", "10.0.0.0/29", "-exclude", "10.0.0.0/33", "-stdout"); err == nil {
+		t.Error("expected an error for an invalid exclusion")
+	}
+}
+
+func TestExcludeFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "exclude.txt")
+	if err := os.WriteFile(path, []byte("# management\n10.0.0.0/30\n\n10.0.0.8/32 # honeypot\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file's networks are added to any given with -exclude
+	got := mustRun(t, "-cidr", "10.0.0.0/28", "-exclude-file", path, "-exclude", "10.0.0.15/32", "-stdout")
+	equalLines(t, got, []string{"10.0.0.4", "10.0.0.5", "10.0.0.6", "10.0.0.7", "10.0.0.9", "10.0.0.10", "10.0.0.11", "10.0.0.12", "10.0.0.13", "10.0.0.14"})
+
+	// Every invalid line is reported by number
+	bad := filepath.Join(dir, "bad.txt")
+	if err := os.WriteFile(bad, []byte("10.0.0.0/30\nbogus\n\n10.0.0.300/32\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := run(t, "-cidr", "10.0.0.0/28", "-exclude-file", bad, "-stdout")
+	if !errors.Is(err, iplist.ErrInvalidCIDR) {
+		t.Fatalf("got %v, want ErrInvalidCIDR", err)
+	}
+	for _, want := range []string{"line 2: ", "line 4: "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't report %q: %v", want, err)
+		}
+	}
+	if _, _, err := run(t, "-cidr", "10.0.0.0/28", "-exclude", "bogus", "-stdout"); !errors.Is(err, iplist.ErrInvalidCIDR) {
+		t.Errorf("-exclude: got %v, want ErrInvalidCIDR", err)
+	}
+}
+
+func TestExcludedRuns(t *testing.T) {
+	g := testGenerator(t, io.Discard, "10.0.0.0/24")
+	var excludes []*net.IPNet
+	for _, cidr := range []string{"10.0.0.16/28", "10.0.0.0/30", "10.0.0.24/29", "10.0.0.32/28", "10.0.1.0/24", "10.0.0.252/30"} {
+		_, ipnet, _ := net.ParseCIDR(cidr)
+		excludes = append(excludes, ipnet)
+	}
+	got := excludedRuns(g.targets[0].span, excludes)
+	want := []offsetRun{{0, 3}, {16, 47}, {252, 255}}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func BenchmarkExclude(b *testing.B) {
+	_, child, _ := net.ParseCIDR("10.0.2.0/24")
+	excludes := []*net.IPNet{child}
+	b.Run("around", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			g := testGenerator(b, io.Discard, "10.0.0.0/16")
+			g.filters.excludes = excludes
+			if err := g.writeAround(g.targets[0].span, 0); err != nil {
+				b.Fatal(err)
+			}
+			finish(b, g)
+		}
+	})
+	b.Run("per-address", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			g := testGenerator(b, io.Discard, "10.0.0.0/16")
+			g.filters.excludes = excludes
+			if err := writeGeneric(g); err != nil {
+				b.Fatal(err)
+			}
+			finish(b, g)
+		}
+	})
+}
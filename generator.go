@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"math/rand"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// errLimitReached stops enumeration once -limit addresses have been written
+var errLimitReached = errors.New("limit reached")
+
+// progressInterval is how many addresses are processed between progress
+// updates and cancellation checks
+const progressInterval = 10000
+
+// stopped reports whether err ended the run early but left a complete,
+// valid output behind: the limit was reached or the run was cancelled
+func stopped(err error) bool {
+	return err == errLimitReached || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// tally counts the addresses written and skipped during a run
+type tally struct {
+	written    int // Addresses written to the output
+	reserved   int // Network/broadcast addresses skipped by -usable
+	excluded   int // Addresses inside an -exclude range
+	duplicates int // Addresses already written, skipped by -dedupe
+	nonPublic  int // Addresses in reserved blocks, skipped by -public-only
+	present    int // Addresses already in the -dedupe-against file
+	unmatched  int // Addresses whose text doesn't match the -match pattern
+}
+
+// add merges the counts from another tally
+func (tl *tally) add(other tally) {
+	tl.written += other.written
+	tl.reserved += other.reserved
+	tl.excluded += other.excluded
+	tl.duplicates += other.duplicates
+	tl.nonPublic += other.nonPublic
+	tl.present += other.present
+	tl.unmatched += other.unmatched
+}
+
+// reservedBlocks are the special-purpose networks skipped by -public-only:
+// private, shared, loopback, link-local, documentation, benchmarking,
+// multicast and other reserved space for IPv4 and IPv6
+var reservedBlocks = mustParseCIDRs(
+	// IPv4
+	"0.0.0.0/8",       // "This" network
+	"10.0.0.0/8",      // Private (RFC 1918)
+	"100.64.0.0/10",   // Shared address space (carrier-grade NAT)
+	"127.0.0.0/8",     // Loopback
+	"169.254.0.0/16",  // Link-local
+	"172.16.0.0/12",   // Private (RFC 1918)
+	"192.0.0.0/24",    // IETF protocol assignments
+	"192.0.2.0/24",    // Documentation (TEST-NET-1)
+	"192.88.99.0/24",  // Deprecated 6to4 relay anycast
+	"192.168.0.0/16",  // Private (RFC 1918)
+	"198.18.0.0/15",   // Benchmarking
+	"198.51.100.0/24", // Documentation (TEST-NET-2)
+	"203.0.113.0/24",  // Documentation (TEST-NET-3)
+	"224.0.0.0/4",     // Multicast
+	"240.0.0.0/4",     // Reserved, including broadcast
+	// IPv6
+	"::/128",        // Unspecified
+	"::1/128",       // Loopback
+	"64:ff9b::/96",  // IPv4/IPv6 translation
+	"100::/64",      // Discard-only
+	"2001::/23",     // IETF protocol assignments
+	"2001:db8::/32", // Documentation
+	"fc00::/7",      // Unique local
+	"fe80::/10",     // Link-local
+	"ff00::/8",      // Multicast
+)
+
+// mustParseCIDRs parses a fixed list of CIDR ranges, panicking on a typo
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, ipnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = ipnet
+	}
+	return networks
+}
+
+// filters decides which enumerated addresses make it into the output
+type filters struct {
+	usable     bool                  // Skip IPv4 network and broadcast addresses
+	excludes   []*net.IPNet          // Networks to omit
+	publicOnly bool                  // Skip addresses in reservedBlocks
+	seen       map[string]struct{}   // Addresses already written, nil unless deduplicating
+	existing   map[[16]byte]struct{} // Addresses in the -dedupe-against file, nil unless given
+	approx     *bloom                // Bloom filter of written addresses, nil unless -dedupe-approx
+	match      *regexp.Regexp        // Pattern each address's text must match, nil for all
+}
+
+// admit applies the filters to ip from target t, recording skipped
+// addresses in tl, and reports whether ip should be written
+func (f *filters) admit(ip net.IP, t target, tl *tally) bool {
+	if f.usable && hasBroadcast(t) && (ip.Equal(t.span.First) || ip.Equal(t.span.Last)) {
+		tl.reserved++
+		return false
+	}
+
+	if containedIn(ip, f.excludes) {
+		tl.excluded++
+		return false
+	}
+
+	if f.publicOnly && containedIn(ip, reservedBlocks) {
+		tl.nonPublic++
+		return false
+	}
+
+	if f.match != nil && !f.match.MatchString(ip.String()) {
+		tl.unmatched++
+		return false
+	}
+
+	if f.existing != nil {
+		if _, ok := f.existing[[16]byte(ip.To16())]; ok {
+			tl.present++
+			return false
+		}
+	}
+
+	if f.seen != nil {
+		key := string(ip.To16())
+		if _, ok := f.seen[key]; ok {
+			tl.duplicates++
+			return false
+		}
+		f.seen[key] = struct{}{}
+	}
+
+	if f.approx != nil && f.approx.add(ip.To16()) {
+		tl.duplicates++
+		return false
+	}
+
+	return true
+}
+
+// progress prints the running count, percentage done and estimated time
+// remaining every progressInterval addresses. The total is counted up front
+// over every target, so the percentage and ETA cover the whole job rather
+// than starting over with each block, and the block being enumerated is
+// named alongside when there are several. It is safe for concurrent use by
+// workers.
+type progress struct {
+	out       io.Writer     // Destination for updates
+	json      bool          // Write updates as JSON events
+	rate      int           // Addresses written per second under -rate, 0 if unthrottled
+	total     uint64        // Addresses the run will process, across every target
+	blocks    int           // Number of targets in the run
+	block     atomic.Int64  // 1-based position of the target being enumerated
+	limit     int           // Write limit, which may end the run early
+	start     time.Time     // When generation began, for the ETA
+	bar       bool          // Redraw one bar line in place, for a terminal
+	drawn     atomic.Bool   // Whether a bar is on screen to be erased
+	processed atomic.Uint64 // Addresses enumerated, written or not
+	written   atomic.Uint64 // Addresses written
+}
+
+// progressBarWidth is the number of cells in the terminal progress bar
+const progressBarWidth = 30
+
+// step records one processed address, which was written if wrote is set
+func (p *progress) step(wrote bool) {
+	n := p.processed.Add(1)
+	written := p.written.Load()
+	if wrote {
+		written = p.written.Add(1)
+	}
+	if n%progressInterval != 0 {
+		return
+	}
+
+	// A limit can finish the run before the range is exhausted, so take
+	// whichever measure is further along
+	done := float64(n) / float64(p.total)
+	if p.limit > 0 {
+		if byLimit := float64(written) / float64(p.limit); byLimit > done {
+			done = byLimit
+		}
+	}
+
+	// Estimate the remaining time from the running average speed
+	elapsed := time.Since(p.start)
+	eta := time.Duration(float64(elapsed) * (1 - done) / done)
+
+	// A throttled run can't finish faster than the rate allows, even if it
+	// started out quicker
+	if p.rate > 0 {
+		throttled := time.Duration((1 - done) * float64(p.total) / float64(p.rate) * float64(time.Second))
+		eta = max(eta, throttled)
+	}
+	if p.json {
+		writeEvent(p.out, progressEvent{
+			Event:     "progress",
+			Count:     written,
+			Processed: n,
+			Total:     p.total,
+			Block:     p.current(),
+			Blocks:    p.blocks,
+			Percent:   done * 100,
+			ElapsedMs: elapsed.Milliseconds(),
+			EtaMs:     eta.Milliseconds(),
+		})
+		return
+	}
+	if p.bar {
+		p.draw(written, done, elapsed, eta)
+		return
+	}
+	if b := p.current(); b > 0 {
+		fmt.Fprintf(p.out, "Generated %d IPs... %.1f%% done (block %d of %d), ETA %v\n", written, done*100, b, p.blocks, eta.Round(100*time.Millisecond))
+		return
+	}
+	fmt.Fprintf(p.out, "Generated %d IPs... %.1f%% done, ETA %v\n", written, done*100, eta.Round(100*time.Millisecond))
+}
+
+// enter records that target n (0-based) is being enumerated next
+func (p *progress) enter(n int) {
+	p.block.Store(int64(n + 1))
+}
+
+// current returns the 1-based position of the target being enumerated, or
+// 0 when the run has a single target or none is under way, as under
+// -sample and -shuffle which draw from every target at once
+func (p *progress) current() int {
+	if p.blocks < 2 {
+		return 0
+	}
+	return int(p.block.Load())
+}
+
+// draw redraws the terminal progress bar over the previous one: a carriage
+// return goes back to the start of the line and the escape at the end
+// clears anything left over from a longer line
+func (p *progress) draw(written uint64, done float64, elapsed, eta time.Duration) {
+	filled := min(int(done*progressBarWidth), progressBarWidth)
+	bar := strings.Repeat("=", filled)
+	if filled < progressBarWidth {
+		bar += ">" + strings.Repeat(" ", progressBarWidth-filled-1)
+	}
+	speed := float64(written) / elapsed.Seconds()
+	block := ""
+	if b := p.current(); b > 0 {
+		block = fmt.Sprintf(", block %d/%d", b, p.blocks)
+	}
+	fmt.Fprintf(p.out, "\r[%s] %5.1f%% %d/%d IPs%s, %.0f IPs/s, ETA %v\x1b[K", bar, done*100, written, p.total, block, speed, eta.Round(time.Second))
+	p.drawn.Store(true)
+}
+
+// clear erases the progress bar, if one was drawn, so the summary starts
+// on a clean line
+func (p *progress) clear() {
+	if p.drawn.Swap(false) {
+		fmt.Fprint(p.out, "\r\x1b[K")
+	}
+}
+
+// skip records n addresses passed over without being enumerated, such as
+// an excluded block jumped over whole
+func (p *progress) skip(n uint64) {
+	p.processed.Add(n)
+}
+
+// generator holds the state shared across every target in a run
+type generator struct {
+	ctx       context.Context // Cancels the run between addresses
+	config    *Config         // Run configuration
+	targets   []target        // Everything being enumerated, in order
+	perTarget []int           // Addresses written from each target
+	writer    *bufio.Writer   // Buffered output stream
+	formatter formatter       // Renders each address
+	filters   *filters        // Decides which addresses are written
+	tally     tally           // Written and skipped counts so far
+	progress  *progress       // Periodic progress output
+	limit     int             // Maximum addresses to write, 0 for no limit
+	step      uint64          // Distance between enumerated addresses
+	octets    []uint32        // Last octets written under -last-octet, nil for all
+	rng       *rand.Rand      // The run's one source of randomness, seeded from -seed
+	group     int             // Prefix length of commented address groups, 0 for none
+	rate      int             // Maximum addresses written per second, 0 for no limit
+	jitter    float64         // Random variance of each paced delay, as a fraction of it
+	due       time.Time       // When the next jittered address is due, zero before the first
+	maxLines  int             // Addresses per file before rotating, 0 for one file
+	basePath  string          // Output path that rotated file numbers are added to
+	lines     int             // Addresses written to the current file
+	rotated   []fileSummary   // Files finished under -max-lines, in order
+	out       *output         // Destination currently being written
+	archive   *archive        // Tar archive receiving -split files, nil for loose files
+	files     int             // Output files completed so far
+	visited   uint64          // Addresses passed to emit, for cancellation checks
+
+	checkpoint *checkpointer // Saves progress for -checkpoint, nil without it
+}
+
+// begin starts writing to out with a fresh formatter
+func (g *generator) begin(out *output) error {
+	g.out = out
+	g.writer = out.writer
+	g.lines = 0
+	g.formatter = newFormatter(g.config, g.targets)
+	if g.group > 0 {
+		g.formatter = &groupFormatter{formatter: g.formatter, prefix: g.group}
+	}
+	if err := g.formatter.begin(g.writer); err != nil {
+		return writeError(err)
+	}
+	return nil
+}
+
+// end closes out the format and the current output
+func (g *generator) end() error {
+	if err := g.formatter.end(g.writer); err != nil {
+		return writeError(err)
+	}
+	if err := g.out.close(); err != nil {
+		return err
+	}
+	if g.maxLines > 0 {
+		g.rotated = append(g.rotated, fileSummary{Path: g.out.path, Count: g.lines})
+	}
+	return nil
+}
+
+// writeRange enumerates every step-th address of span, part of target i,
+// writing those that pass the filters, from the top down with -reverse. It
+// returns errLimitReached if the limit stops it before the end of the span.
+func (g *generator) writeRange(span iplist.Range, i int) error {
+	if g.config.boundaries {
+		return g.writeBoundaries(span, i)
+	}
+	if g.octets != nil {
+		return g.writeOctets(span, i)
+	}
+	if g.config.shuffleIPs {
+		return g.writeShuffledHosts(span, i)
+	}
+	if g.filters.excludes != nil && g.step <= 1 {
+		return g.writeAround(span, i)
+	}
+	if g.fastIPv4(span) {
+		return g.writeIPv4(span, i)
+	}
+
+	emit := func(ip net.IP) error {
+		return g.emit(ip, i)
+	}
+	if g.config.reverse {
+		return iplist.EnumerateRangeReverse(span, g.step, emit)
+	}
+	return iplist.EnumerateRangeStep(span, g.step, emit)
+}
+
+// emit writes ip from target i if it passes the filters, returning
+// errLimitReached instead once the limit has been hit, or the context's
+// error once it is cancelled
+func (g *generator) emit(ip net.IP, i int) error {
+	g.visited++
+	if g.visited%progressInterval == 0 {
+		if err := g.ctx.Err(); err != nil {
+			return err
+		}
+	}
+
+	if !g.filters.admit(ip, g.targets[i], &g.tally) {
+		g.progress.step(false)
+		return nil
+	}
+	if g.limit > 0 && g.tally.written >= g.limit {
+		return errLimitReached
+	}
+
+	if g.maxLines > 0 && g.lines >= g.maxLines {
+		if err := g.rotate(); err != nil {
+			return err
+		}
+	}
+	if g.rate > 0 {
+		if err := g.pace(); err != nil {
+			return err
+		}
+	}
+
+	if err := g.formatter.writeIP(g.writer, ip); err != nil {
+		return writeError(err)
+	}
+	g.tally.written++
+	g.lines++
+	g.perTarget[i]++
+	if g.checkpoint != nil {
+		if err := g.written(ip, i); err != nil {
+			return err
+		}
+	}
+
+	// Show progress for large ranges
+	g.progress.step(true)
+	return nil
+}
+
+// writeBoundaries writes only the first and last address of span, without
+// visiting the hosts between them. A single-address span such as a /32
+// has one boundary, written once.
+func (g *generator) writeBoundaries(span iplist.Range, i int) error {
+	ends := []net.IP{span.First, span.Last}
+	if span.First.Equal(span.Last) {
+		ends = ends[:1]
+	} else if g.config.reverse {
+		ends[0], ends[1] = ends[1], ends[0]
+	}
+	for _, ip := range ends {
+		if err := g.emit(ip, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pace waits until the next address is due under -rate, measured from the
+// start of the run so the average stays at the rate. Under -jitter each
+// gap is stretched or shrunk at random instead, averaging out to the same
+// rate. Buffered output is flushed before waiting so a consumer receives
+// addresses as they are paced out rather than in buffer-sized bursts.
+func (g *generator) pace() error {
+	due := g.progress.start.Add(time.Duration(float64(g.tally.written) / float64(g.rate) * float64(time.Second)))
+	if g.jitter > 0 {
+		if g.due.IsZero() {
+			g.due = g.progress.start
+		}
+		due = g.due
+		gap := float64(time.Second) / float64(g.rate) * (1 + g.jitter*(2*g.rng.Float64()-1))
+		g.due = g.due.Add(time.Duration(gap))
+	}
+	wait := time.Until(due)
+	if wait <= 0 {
+		return nil
+	}
+
+	if err := g.writer.Flush(); err != nil {
+		return writeError(err)
+	}
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-g.ctx.Done():
+		return g.ctx.Err()
+	}
+}
+
+// newRand returns the one source of randomness for a run, which every
+// random choice draws from in turn: the -sample picks, the -shuffle and
+// -shuffle-hosts orders and the -jitter delays. The same -seed therefore
+// reproduces a run exactly, whichever of them are combined. Without one a
+// seed is picked from the clock and kept in config so it can be reported.
+func newRand(config *Config) *rand.Rand {
+	if config.seed == 0 {
+		config.seed = time.Now().UnixNano()
+	}
+	return rand.New(rand.NewSource(config.seed))
+}
+
+// sampleOffsets picks n distinct offsets below total uniformly at random,
+// returned in ascending order. Floyd's algorithm keeps only the n chosen
+// offsets in memory, never the whole range.
+func sampleOffsets(n int, total uint64, rng *rand.Rand) []uint64 {
+	chosen := make(map[uint64]struct{}, n)
+	for j := total - uint64(n); j < total; j++ {
+		offset := uint64(rng.Int63n(int64(j + 1)))
+		if _, ok := chosen[offset]; ok {
+			offset = j
+		}
+		chosen[offset] = struct{}{}
+	}
+
+	offsets := make([]uint64, 0, n)
+	for offset := range chosen {
+		offsets = append(offsets, offset)
+	}
+	sort.Slice(offsets, func(a, b int) bool { return offsets[a] < offsets[b] })
+	return offsets
+}
+
+// shardBounds returns the first and last offset of partition shard, of
+// shards, of a combined address space of size addresses. The partitions
+// are contiguous and differ in size by at most one address; an empty one,
+// when there are fewer addresses than shards, has first past last.
+func shardBounds(size uint64, shard, shards int) (uint64, uint64) {
+	// Multiplying before dividing spreads the remainder evenly, and big
+	// ints keep the product from overflowing
+	at := func(k int) uint64 {
+		n := new(big.Int).SetUint64(size)
+		n.Mul(n, big.NewInt(int64(k)))
+		return n.Div(n, big.NewInt(int64(shards))).Uint64()
+	}
+	lo, end := at(shard-1), at(shard)
+	if lo == end {
+		return size, size - 1
+	}
+	return lo, end - 1
+}
+
+// windowSpans returns the part of each target's span between offsets first
+// and last, inclusive, of the combined address space of all targets. A
+// target entirely outside the window gets a zero Range.
+func windowSpans(targets []target, first, last uint64) []iplist.Range {
+	spans := make([]iplist.Range, len(targets))
+	base := uint64(0)
+	for i, t := range targets {
+		// This target covers offsets base through base+size-1
+		size := t.span.Size().Uint64()
+		lo, hi := max(first, base), min(last, base+size-1)
+		if lo <= hi {
+			spans[i] = iplist.Range{First: t.span.At(lo - base), Last: t.span.At(hi - base)}
+		}
+		base += size
+	}
+	return spans
+}
+
+// writeOffsets writes the address at each offset into the combined address
+// space of all targets, in the order given
+func (g *generator) writeOffsets(offsets []uint64) error {
+	// bases[i] is the offset of the first address of target i
+	bases := make([]uint64, len(g.targets))
+	next := uint64(0)
+	for i, t := range g.targets {
+		bases[i] = next
+		next += t.span.Size().Uint64()
+	}
+
+	for _, offset := range offsets {
+		i := sort.Search(len(bases), func(i int) bool { return bases[i] > offset }) - 1
+		if err := g.emit(g.targets[i].span.At(offset-bases[i]), i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReservedBlocks(t *testing.T) {
+	tests := []struct {
+		ip       string
+		reserved bool
+	}{
+		{"9.255.255.255", false},
+		{"10.0.0.0", true},
+		{"10.255.255.255", true},
+		{"11.0.0.0", false},
+		{"100.63.255.255", false},
+		{"100.64.0.0", true},
+		{"100.127.255.255", true},
+		{"172.15.255.255", false},
+		{"172.16.0.0", true},
+		{"172.31.255.255", true},
+		{"172.32.0.0", false},
+		{"192.167.255.255", false},
+		{"192.168.0.0", true},
+		{"192.169.0.0", false},
+		{"169.254.1.1", true},
+		{"127.0.0.1", true},
+		{"223.255.255.255", false},
+		{"224.0.0.0", true},
+		{"255.255.255.255", true},
+		{"8.8.8.8", false},
+		{"2001:db8::1", true},
+		{"2001:4860:4860::8888", false},
+		{"fe80::1", true},
+		{"fc00::1", true},
+		{"::1", true},
+	}
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		if got := containedIn(ip, reservedBlocks); got != tt.reserved {
+			t.Errorf("%s: reserved = %v, want %v", tt.ip, got, tt.reserved)
+		}
+	}
+}
+
+func TestPublicOnly(t *testing.T) {
+	stdout, stderr, err := run(t, "-range", "172.15.255.254-172.16.0.1", "-public-only", "-stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	equalLines(t, stdout, []string{"172.15.255.254", "172.15.255.255"})
+	if !strings.Contains(stderr, "Reserved Ranges Skipped: 2\n") {
+		t.Errorf("summary doesn't count the reserved IPs:\n%s", stderr)
+	}
+
+	got := mustRun(t, "-range", "223.255.255.254-224.0.0.1", "-public-only", "-stdout")
+	equalLines(t, got, []string{"223.255.255.254", "223.255.255.255"})
+}
+
+func TestProgressCoversWholeJob(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cidrs.txt")
+	if err := os.WriteFile(path, []byte("10.0.0.0/16\n10.1.0.0/17\n10.2.0.0/18\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, stderr, err := run(t, "-cidr-file", path, "-stdout", "-yes", "-log-json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every update measures against the sum of the blocks, and the
+	// percentage keeps climbing from one block into the next
+	var updates []progressEvent
+	for _, line := range lines(stderr) {
+		var e progressEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		if e.Event == "progress" {
+			updates = append(updates, e)
+		}
+	}
+	if want := (1<<16 + 1<<15 + 1<<14) / progressInterval; len(updates) != want {
+		t.Fatalf("got %d progress updates, want %d", len(updates), want)
+	}
+	for i, e := range updates {
+		if e.Total != 1<<16+1<<15+1<<14 || e.Blocks != 3 {
+			t.Errorf("update %d: total %d of %d blocks", i, e.Total, e.Blocks)
+		}
+		if i > 0 && (e.Percent <= updates[i-1].Percent || e.Block < updates[i-1].Block) {
+			t.Errorf("update %d went back from %.1f%% in block %d to %.1f%% in block %d", i, updates[i-1].Percent, updates[i-1].Block, e.Percent, e.Block)
+		}
+	}
+	if first, last := updates[0], updates[len(updates)-1]; first.Block != 1 || last.Block != 3 || last.Percent < 90 {
+		t.Errorf("updates run from block %d to %d, ending at %.1f%%", first.Block, last.Block, last.Percent)
+	}
+}
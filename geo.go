@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// geoMetadataMarker starts the metadata section at the end of a MaxMind DB
+// file
+var geoMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// geoWarnIPs is the largest run -format enriched looks up without warning
+const geoWarnIPs = 1 << 16
+
+// errGeoData is returned for a record that runs off the end of the file or
+// has a type the MaxMind DB format doesn't define
+var errGeoData = errors.New("invalid MaxMind DB data")
+
+// geoDB is a MaxMind DB (.mmdb) file read into memory, such as GeoLite2-ASN
+// or GeoLite2-Country. It holds the binary search tree over address bits
+// and the data section its leaves point into.
+type geoDB struct {
+	tree       []byte // Search tree, nodes records of recordSize bits each
+	data       []byte // Data section the tree points into
+	nodeCount  uint   // Number of nodes in the tree
+	recordSize uint   // Bits per record: 24, 28 or 32
+	ipVersion  int    // 4 for an IPv4-only tree, 6 for an IPv6 one
+	ipv4Start  uint   // Node reached after the 96 zero bits of ::/96
+}
+
+// geoDBs are the databases named by -geo-db, each field of an enriched
+// row coming from the first one that has it
+type geoDBs []*geoDB
+
+// openGeoDBs reads each database in the comma-separated -geo-db list. They
+// are opened once per run and shared by every formatter.
+func openGeoDBs(list string) (geoDBs, error) {
+	var dbs geoDBs
+	for _, path := range strings.Split(list, ",") {
+		db, err := openGeoDB(strings.TrimSpace(path))
+		if err != nil {
+			return nil, err
+		}
+		dbs = append(dbs, db)
+	}
+	return dbs, nil
+}
+
+// openGeoDB reads the MaxMind DB file at path and checks its metadata
+func openGeoDB(path string) (*geoDB, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading -geo-db: %v", err)
+	}
+	at := bytes.LastIndex(file, geoMetadataMarker)
+	if at < 0 {
+		return nil, fmt.Errorf("-geo-db %s is not a MaxMind DB file", path)
+	}
+	meta := file[at+len(geoMetadataMarker):]
+	value, _, err := geoDecode(meta, 0)
+	if err != nil {
+		return nil, fmt.Errorf("-geo-db %s has invalid metadata: %v", path, err)
+	}
+	fields, _ := value.(map[string]any)
+	nodeCount, _ := fields["node_count"].(uint64)
+	recordSize, _ := fields["record_size"].(uint64)
+	ipVersion, _ := fields["ip_version"].(uint64)
+	if recordSize != 24 && recordSize != 28 && recordSize != 32 {
+		return nil, fmt.Errorf("-geo-db %s has unsupported record size %d", path, recordSize)
+	}
+	if ipVersion != 4 && ipVersion != 6 {
+		return nil, fmt.Errorf("-geo-db %s has unsupported IP version %d", path, ipVersion)
+	}
+
+	// The tree is followed by 16 zero bytes and then the data section
+	treeSize := nodeCount * recordSize / 4
+	if treeSize+16 > uint64(at) {
+		return nil, fmt.Errorf("-geo-db %s is truncated", path)
+	}
+	db := &geoDB{
+		tree:       file[:treeSize],
+		data:       file[treeSize+16 : at],
+		nodeCount:  uint(nodeCount),
+		recordSize: uint(recordSize),
+		ipVersion:  int(ipVersion),
+	}
+	if db.ipVersion == 6 {
+		for i := 0; i < 96 && db.ipv4Start < db.nodeCount; i++ {
+			db.ipv4Start = db.record(db.ipv4Start, 0)
+		}
+	}
+	return db, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of node
+func (db *geoDB) record(node uint, bit int) uint {
+	switch db.recordSize {
+	case 24:
+		b := db.tree[node*6+uint(bit)*3:]
+		return uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+	case 28:
+		b := db.tree[node*7:]
+		if bit == 0 {
+			return uint(b[3]&0xf0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0f)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		return uint(binary.BigEndian.Uint32(db.tree[node*8+uint(bit)*4:]))
+	}
+}
+
+// lookup returns the record for ip, or nil if the database has no network
+// containing it. An IPv6 address can't match an IPv4-only database.
+func (db *geoDB) lookup(ip net.IP) map[string]any {
+	node := uint(0)
+	key := ip.To4()
+	if key == nil {
+		if db.ipVersion == 4 {
+			return nil
+		}
+		key = ip.To16()
+	} else if db.ipVersion == 6 {
+		node = db.ipv4Start
+	}
+
+	for i := 0; i < len(key)*8 && node < db.nodeCount; i++ {
+		node = db.record(node, int(key[i/8]>>(7-i%8)&1))
+	}
+	if node <= db.nodeCount {
+		return nil
+	}
+
+	// A record past the tree points into the data section, offset by the
+	// node count and the 16-byte separator. One that can't be decoded is
+	// treated as no match rather than failing the run.
+	value, _, err := geoDecode(db.data, node-db.nodeCount-16)
+	if err != nil {
+		return nil
+	}
+	fields, _ := value.(map[string]any)
+	return fields
+}
+
+// geoDecode decodes the MaxMind DB data field at offset in data, returning
+// its value and the offset just past it. Maps decode to map[string]any,
+// arrays to []any, strings to string, unsigned integers to uint64, signed
+// ones to int64, doubles and floats to float64, and bytes and uint128s to
+// []byte.
+func geoDecode(data []byte, offset uint) (any, uint, error) {
+	if offset >= uint(len(data)) {
+		return nil, 0, errGeoData
+	}
+	ctrl := data[offset]
+	offset++
+	kind := ctrl >> 5
+
+	// Pointers carry their own size bits and refer to another field
+	if kind == 1 {
+		n := uint(ctrl>>3&3) + 1
+		if offset+n > uint(len(data)) {
+			return nil, 0, errGeoData
+		}
+		p := uint(ctrl & 7)
+		if n == 4 {
+			p = 0
+		}
+		for _, b := range data[offset : offset+n] {
+			p = p<<8 | uint(b)
+		}
+		p += []uint{0, 2048, 526336, 0}[n-1]
+		if p >= uint(len(data)) || data[p]>>5 == 1 {
+			return nil, 0, errGeoData
+		}
+		value, _, err := geoDecode(data, p)
+		return value, offset + n, err
+	}
+
+	if kind == 0 {
+		if offset >= uint(len(data)) {
+			return nil, 0, errGeoData
+		}
+		kind = 7 + data[offset]
+		offset++
+	}
+	size := uint(ctrl & 0x1f)
+	if size >= 29 {
+		n := size - 28
+		if offset+n > uint(len(data)) {
+			return nil, 0, errGeoData
+		}
+		extra := uint(0)
+		for _, b := range data[offset : offset+n] {
+			extra = extra<<8 | uint(b)
+		}
+		size = []uint{29, 285, 65821}[n-1] + extra
+		offset += n
+	}
+
+	// Maps and arrays hold size fields; booleans are the size itself; every
+	// other type is size bytes of payload
+	if (kind == 7 || kind == 11) && size > uint(len(data)) {
+		return nil, 0, errGeoData
+	}
+	switch kind {
+	case 7:
+		m := make(map[string]any, size)
+		for i := uint(0); i < size; i++ {
+			k, next, err := geoDecode(data, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			name, ok := k.(string)
+			if !ok {
+				return nil, 0, errGeoData
+			}
+			v, next, err := geoDecode(data, next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[name] = v
+			offset = next
+		}
+		return m, offset, nil
+	case 11:
+		a := make([]any, 0, size)
+		for i := uint(0); i < size; i++ {
+			v, next, err := geoDecode(data, offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, v)
+			offset = next
+		}
+		return a, offset, nil
+	case 14:
+		return size != 0, offset, nil
+	}
+
+	if offset+size > uint(len(data)) {
+		return nil, 0, errGeoData
+	}
+	b := data[offset : offset+size]
+	offset += size
+	switch kind {
+	case 2:
+		return string(b), offset, nil
+	case 3:
+		if size != 8 {
+			return nil, 0, errGeoData
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), offset, nil
+	case 15:
+		if size != 4 {
+			return nil, 0, errGeoData
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), offset, nil
+	case 4:
+		return b, offset, nil
+	case 5, 6, 9:
+		if size > 8 {
+			return nil, 0, errGeoData
+		}
+		n := uint64(0)
+		for _, c := range b {
+			n = n<<8 | uint64(c)
+		}
+		return n, offset, nil
+	case 8:
+		if size > 4 {
+			return nil, 0, errGeoData
+		}
+		n := uint32(0)
+		for _, c := range b {
+			n = n<<8 | uint32(c)
+		}
+		return int64(int32(n)), offset, nil
+	case 10:
+		return b, offset, nil
+	}
+	return nil, 0, errGeoData
+}
+
+// geoASN returns the autonomous system number in a record, as GeoLite2-ASN
+// (autonomous_system_number) or an "asn": "AS15169" style database stores
+// it, or an empty string if it has none
+func geoASN(fields map[string]any) string {
+	if n, ok := fields["autonomous_system_number"].(uint64); ok {
+		return strconv.FormatUint(n, 10)
+	}
+	if s, ok := fields["asn"].(string); ok {
+		return strings.TrimPrefix(strings.ToUpper(s), "AS")
+	}
+	return ""
+}
+
+// geoCountry returns the ISO country code in a record, from country or else
+// registered_country as GeoLite2-Country and City store them, or a plain
+// "country" string, or an empty string if it has none
+func geoCountry(fields map[string]any) string {
+	for _, key := range []string{"country", "registered_country"} {
+		switch v := fields[key].(type) {
+		case map[string]any:
+			if code, ok := v["iso_code"].(string); ok {
+				return code
+			}
+		case string:
+			return v
+		}
+	}
+	return ""
+}
+
+// enrichedFormatter writes each address with its autonomous system number
+// and country from the -geo-db databases as ip,asn,country rows. A field no
+// database has for the address is left blank.
+type enrichedFormatter struct {
+	dbs    geoDBs // Databases looked up, first match wins per field
+	header bool   // Whether to start with the ip,asn,country row
+}
+
+func (f *enrichedFormatter) begin(w *bufio.Writer) error {
+	if !f.header {
+		return nil
+	}
+	_, err := w.WriteString("ip,asn,country\n")
+	return err
+}
+
+func (f *enrichedFormatter) writeIP(w *bufio.Writer, ip net.IP) error {
+	asn, country := "", ""
+	for _, db := range f.dbs {
+		if asn != "" && country != "" {
+			break
+		}
+		fields := db.lookup(ip)
+		if asn == "" {
+			asn = geoASN(fields)
+		}
+		if country == "" {
+			country = geoCountry(fields)
+		}
+	}
+	_, err := w.WriteString(ip.String() + "," + asn + "," + country + "\n")
+	return err
+}
+
+func (f *enrichedFormatter) end(w *bufio.Writer) error { return nil }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The testdata/geo-v{4,6}-{24,28,32}.mmdb fixtures hold the same records
+// in IPv4 and IPv6 trees at each record size:
+//
+//	1.2.3.0/24     AS13335, country AU, organization by pointer
+//	8.8.8.0/30     AS15169, registered country JP by pointer, anycast
+//	9.9.9.9/32     a uint64 ASN, country CH and one field of every
+//	               extended type
+//	2001:db8::/32  "asn": "AS64500", "country": "NL" (IPv6 trees only)
+var geoFixtures = []string{"geo-v4-24", "geo-v4-28", "geo-v4-32", "geo-v6-24", "geo-v6-28", "geo-v6-32"}
+
+// openFixture opens testdata/name.mmdb
+func openFixture(t *testing.T, name string) *geoDB {
+	t.Helper()
+	db, err := openGeoDB(filepath.Join("testdata", name+".mmdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestGeoLookup(t *testing.T) {
+	for _, name := range geoFixtures {
+		db := openFixture(t, name)
+		for _, tc := range []struct {
+			ip, asn, country string
+		}{
+			{"1.2.3.0", "13335", "AU"},
+			{"1.2.3.255", "13335", "AU"},
+			{"8.8.8.3", "15169", "JP"},
+			{"9.9.9.9", "1099511627776", "CH"},
+			{"1.2.4.0", "", ""},
+			{"8.8.8.4", "", ""},
+			{"10.0.0.1", "", ""},
+			{"255.255.255.255", "", ""},
+		} {
+			fields := db.lookup(net.ParseIP(tc.ip))
+			if asn, country := geoASN(fields), geoCountry(fields); asn != tc.asn || country != tc.country {
+				t.Errorf("%s: %s is AS%q in %q, want AS%q in %q", name, tc.ip, asn, country, tc.asn, tc.country)
+			}
+		}
+
+		// Pointers are followed to the shared value
+		if org := db.lookup(net.ParseIP("1.2.3.4"))["autonomous_system_organization"]; org != "Example Org" {
+			t.Errorf("%s: organization %v", name, org)
+		}
+	}
+}
+
+func TestGeoLookupIPv6(t *testing.T) {
+	for _, name := range geoFixtures {
+		db := openFixture(t, name)
+		fields := db.lookup(net.ParseIP("2001:db8::1"))
+		if db.ipVersion == 4 {
+			if fields != nil {
+				t.Errorf("%s: an IPv4 tree matched an IPv6 address: %v", name, fields)
+			}
+			continue
+		}
+		if asn, country := geoASN(fields), geoCountry(fields); asn != "64500" || country != "NL" {
+			t.Errorf("%s: 2001:db8::1 is AS%q in %q", name, asn, country)
+		}
+		if fields := db.lookup(net.ParseIP("2001:db9::")); fields != nil {
+			t.Errorf("%s: 2001:db9:: matched %v", name, fields)
+		}
+
+		// IPv4 addresses are found under ::/96, however they are spelled
+		for _, ip := range []string{"1.2.3.4", "::ffff:1.2.3.4"} {
+			if asn := geoASN(db.lookup(net.ParseIP(ip))); asn != "13335" {
+				t.Errorf("%s: %s is AS%q", name, ip, asn)
+			}
+		}
+	}
+}
+
+func TestGeoExtendedTypes(t *testing.T) {
+	id := make([]byte, 16)
+	new(big.Int).Lsh(big.NewInt(1), 100).FillBytes(id)
+	want := map[string]any{
+		"autonomous_system_number": uint64(1) << 40,
+		"country":                  map[string]any{"iso_code": "CH"},
+		"offset":                   int64(-5),
+		"id":                       id,
+		"weight":                   1.5,
+		"raw":                      []byte{1, 2},
+		"tags":                     []any{uint64(1), "x", false},
+	}
+	for _, name := range geoFixtures {
+		db := openFixture(t, name)
+		if got := db.lookup(net.ParseIP("9.9.9.9")); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %#v, want %#v", name, got, want)
+		}
+		got := db.lookup(net.ParseIP("1.2.3.4"))
+		if lat := got["location"].(map[string]any)["latitude"]; lat != -33.5 {
+			t.Errorf("%s: latitude %v", name, lat)
+		}
+		if anycast := db.lookup(net.ParseIP("8.8.8.1"))["is_anycast"]; anycast != true {
+			t.Errorf("%s: is_anycast %v", name, anycast)
+		}
+	}
+}
+
+func TestGeoRecordSizes(t *testing.T) {
+	// The fixtures' trees are too small to set the top bits of a record,
+	// so check the packing of each size directly
+	tests := []struct {
+		size        uint
+		node        []byte
+		left, right uint
+	}{
+		{24, []byte{0x12, 0x34, 0x56, 0xab, 0xcd, 0xef}, 0x123456, 0xabcdef},
+		{28, []byte{0x12, 0x34, 0x56, 0x9a, 0xbc, 0xde, 0xf0}, 0x9123456, 0xabcdef0},
+		{32, []byte{0x12, 0x34, 0x56, 0x78, 0x9a, 0xbc, 0xde, 0xf0}, 0x12345678, 0x9abcdef0},
+	}
+	for _, tt := range tests {
+		// The node of interest is the second, after one of zeros
+		db := &geoDB{tree: append(make([]byte, len(tt.node)), tt.node...), recordSize: tt.size, nodeCount: 2}
+		if left, right := db.record(1, 0), db.record(1, 1); left != tt.left || right != tt.right {
+			t.Errorf("%d-bit records: got %#x and %#x, want %#x and %#x", tt.size, left, right, tt.left, tt.right)
+		}
+	}
+}
+
+func TestOpenGeoDBErrors(t *testing.T) {
+	valid, err := os.ReadFile(filepath.Join("testdata", "geo-v4-24.mmdb"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := bytes.LastIndex(valid, geoMetadataMarker)
+	nodes := fmt.Sprintf("node_count\xa1%c", openFixture(t, "geo-v4-24").nodeCount)
+	dir := t.TempDir()
+	for name, data := range map[string][]byte{
+		"not a database":   []byte("just some text\n"),
+		"empty":            nil,
+		"cut off":          valid[:at+4],
+		"truncated tree":   bytes.Replace(valid, []byte(nodes), []byte("node_count\xa2\x10\x00"), 1),
+		"corrupt metadata": append(valid[:at+len(geoMetadataMarker):at+len(geoMetadataMarker)], 0xe5, 'x'),
+		"record size 25":   bytes.Replace(valid, []byte("record_size\xa1\x18"), []byte("record_size\xa1\x19"), 1),
+		"ip version 5":     bytes.Replace(valid, []byte("ip_version\xa1\x04"), []byte("ip_version\xa1\x05"), 1),
+	} {
+		path := filepath.Join(dir, name+".mmdb")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := openGeoDB(path); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+	if _, err := openGeoDB(filepath.Join(dir, "missing.mmdb")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestGeoCorruptData(t *testing.T) {
+	// A data section cut off anywhere never panics, and a lookup finds
+	// either the whole record or nothing
+	db := openFixture(t, "geo-v6-28")
+	full := db.data
+	for n := 0; n < len(full); n++ {
+		for _, ip := range []string{"1.2.3.4", "8.8.8.1", "9.9.9.9", "2001:db8::1"} {
+			want := db.lookup(net.ParseIP(ip))
+			db.data = full[:n]
+			if got := db.lookup(net.ParseIP(ip)); got != nil && !reflect.DeepEqual(got, want) {
+				t.Errorf("%d bytes: %s decoded to %v, want %v", n, ip, got, want)
+			}
+			db.data = full
+		}
+	}
+
+	// A pointer to a pointer, or past the end, is rejected
+	for _, data := range [][]byte{{0x20, 0x02, 0x20, 0x00}, {0x20, 0x40}, {0x38, 0x00, 0x00, 0x00, 0x00}} {
+		if _, _, err := geoDecode(data, 0); !errors.Is(err, errGeoData) {
+			t.Errorf("% x: got %v, want errGeoData", data, err)
+		}
+	}
+}
+
+func TestEnrichedFormat(t *testing.T) {
+	// The first database with a field wins, so a country-only database
+	// after the ASN one fills in only what the first lacks
+	dbs := fmt.Sprintf("%s,%s", filepath.Join("testdata", "geo-v4-24.mmdb"), filepath.Join("testdata", "geo-v6-32.mmdb"))
+	got := mustRun(t, "-range", "1.2.3.255-1.2.4.0,2001:db8::-2001:db8::1", "-format", "enriched", "-geo-db", dbs, "-stdout")
+	equalLines(t, got, []string{"ip,asn,country", "1.2.3.255,13335,AU", "1.2.4.0,,", "2001:db8::,64500,NL", "2001:db8::1,64500,NL"})
+
+	if _, _, err := run(t, "-cidr", "10.0.0.0/30", "-format", "enriched", "-geo-db", filepath.Join("testdata", "missing.mmdb"), "-stdout"); err == nil {
+		t.Error("expected an error for a missing -geo-db")
+	}
+}
@@ -0,0 +1,3 @@
+module github.com/kumarasakti/ip-list-generator
+
+go 1.21
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bufio"
+	"net"
+)
+
+// groupFormatter wraps a line formatter, starting each prefix-sized block
+// of addresses with a "# network/prefix" comment and separating blocks
+// with a blank line. Addresses must arrive in ascending order, so every
+// block is written in one piece.
+type groupFormatter struct {
+	formatter
+	prefix  int        // Prefix length of each group, e.g. 24
+	current *net.IPNet // Group of the last address written, nil before the first
+}
+
+func (f *groupFormatter) writeIP(w *bufio.Writer, ip net.IP) error {
+	bits := net.IPv6len * 8
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, net.IPv4len*8
+	}
+	mask := net.CIDRMask(f.prefix, bits)
+
+	// Crossing into a new group ends the previous one with a blank line
+	// and names the new one
+	if f.current == nil || !f.current.Contains(ip) {
+		header := ""
+		if f.current != nil {
+			header = "\n"
+		}
+		f.current = &net.IPNet{IP: ip.Mask(mask), Mask: mask}
+		if _, err := w.WriteString(header + "# " + f.current.String() + "\n"); err != nil {
+			return err
+		}
+	}
+	return f.formatter.writeIP(w, ip)
+}
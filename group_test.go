@@ -0,0 +1,49 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGroup(t *testing.T) {
+	got := lines(mustRun(t, "-range", "10.0.4.254-10.0.6.1", "-group", "/24", "-stdout"))
+	var headers []int
+	for i, line := range got {
+		if strings.HasPrefix(line, "#") {
+			headers = append(headers, i)
+		}
+	}
+	// 260 addresses in three groups, with a blank line between each
+	if len(got) != 260+3+2 || len(headers) != 3 {
+		t.Fatalf("got %d lines with headers at %v", len(got), headers)
+	}
+
+	// Each header is preceded by a blank line, except the first, and
+	// followed by the first address of its group
+	want := []struct{ header, first string }{
+		{"# 10.0.4.0/24", "10.0.4.254"},
+		{"# 10.0.5.0/24", "10.0.5.0"},
+		{"# 10.0.6.0/24", "10.0.6.0"},
+	}
+	for k, i := range headers {
+		if got[i] != want[k].header || got[i+1] != want[k].first {
+			t.Errorf("group %d: got %q then %q, want %q then %q", k, got[i], got[i+1], want[k].header, want[k].first)
+		}
+		if k > 0 && got[i-1] != "" {
+			t.Errorf("group %d: header follows %q, not a blank line", k, got[i-1])
+		}
+	}
+	if got[headers[1]-2] != "10.0.4.255" || got[len(got)-1] != "10.0.6.1" {
+		t.Errorf("groups end with %q and %q", got[headers[1]-2], got[len(got)-1])
+	}
+}
+
+func TestGroupPrefix(t *testing.T) {
+	if _, _, err := run(t, "-cidr", "10.0.0.0/24", "-group", "/16", "-stdout"); err == nil {
+		t.Error("expected a -group prefix shorter than the range to be refused")
+	}
+
+	// A group the size of the range gives one header
+	got := lines(mustRun(t, "-cidr", "10.0.0.0/30", "-group", "/30", "-stdout"))
+	equalLines(t, strings.Join(got, "\n"), []string{"# 10.0.0.0/30", "10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3"})
+}
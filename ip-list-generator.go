@@ -2,20 +2,160 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"math/big"
 	"net"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"runtime/debug"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// formatExtensions maps each supported output format to its file extension
+var formatExtensions = map[string]string{
+	"txt":      ".txt",
+	"json":     ".json",
+	"csv":      ".csv",
+	"tsv":      ".tsv",
+	"jsonl":    ".jsonl",
+	"int":      ".txt",
+	"hex":      ".txt",
+	"binary":   ".bin",
+	"range":    ".txt",
+	"nmap":     ".txt",
+	"cidr":     ".txt",
+	"cisco":    ".txt",
+	"juniper":  ".txt",
+	"base64":   ".txt",
+	"enriched": ".csv",
+}
+
+// Build information, set at build time with e.g.
+// -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildDate=$(date -u +%Y-%m-%d)"
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
 )
 
+// maxUnforcedIPs is the largest run allowed without -force
+const maxUnforcedIPs = 1 << 20
+
+// confirmThreshold is the largest run started from a terminal without
+// asking first
+const confirmThreshold = 100000
+
 // Config holds all program configuration parameters
 type Config struct {
-	cidr      string // CIDR range for IP generation
-	outputDir string // Directory to save output file
-	filename  string // Custom filename (optional)
+	cidr       string // Comma-separated CIDR ranges for IP generation
+	outputDir  string // Directory to save output file
+	noMkdir    bool   // Fail instead of creating a missing output directory
+	filename   string // Custom filename (optional)
+	dedupe     bool   // Skip addresses already written by an earlier CIDR
+	sort       bool   // Merge the targets into one ascending, duplicate-free stream
+	dedupeFile string // Existing list whose addresses are skipped
+	exclude    string // Comma-separated CIDR ranges to omit from output
+	exclFile   string // File listing CIDR ranges to omit, one per line
+	usable     bool   // Omit IPv4 network and broadcast addresses
+	stdout     bool   // Write addresses to stdout instead of a file
+	tee        bool   // Write addresses to stdout as well as the output file
+	format     string // Output format (txt, json, jsonl, csv, tsv, int, hex or binary)
+	hexPrefix  bool   // Prefix hex output with 0x
+	gzip       bool   // Gzip-compress the output
+	count      bool   // Only print the number of addresses, writing nothing
+	ipRange    string // Comma-separated start-end IP ranges for IP generation
+	workers    int    // Number of goroutines enumerating each target
+	limit      int    // Stop after this many addresses (0 means no limit)
+	sample     int    // Write this many random distinct addresses (0 writes all)
+	seed       int64  // Random seed for reproducible output (0 picks one)
+	shuffle    bool   // Write addresses in random order
+	shuffleMax int    // Largest range -shuffle will hold in memory
+	shuffleIPs bool   // Shuffle the hosts within each /24 but keep /24 order
+	quiet      bool   // Suppress all non-error output
+	force      bool   // Allow runs larger than maxUnforcedIPs
+	append     bool   // Append to the output file instead of overwriting it
+	split      string // Write one file per subnet of this prefix (e.g. /24)
+	splitJobs  int    // Split files written at once
+	cidrFile   string // File listing CIDR ranges, one per line
+	step       int    // Enumerate every Nth address
+	summarize  string // File of IPs to aggregate into CIDRs ("-" for stdin)
+	merge      string // Comma-separated IP list files or globs to combine
+	subtract   string // CIDRs to remove from the targets, printing the rest as CIDRs
+	links      string // Print the host pairs of each link subnet of this prefix (/31 or /30)
+	noTime     bool   // Leave the timestamp out of the default filename
+	checksum   bool   // Write a .sha256 sidecar next to each output file
+	metrics    string // File to write run metrics to in Prometheus text format
+	report     string // File to write a JSON report of the run to
+	checkpoint string // File recording progress so an interrupted run can resume
+	estimate   bool   // Only print the planned count and output size
+	validate   bool   // Only check the syntax of every input entry
+	logJSON    bool   // Write progress, warnings and the summary as JSON
+	noProgress bool   // Leave out progress updates
+	publicOnly bool   // Skip addresses in private and reserved blocks
+	match      string // Regexp an address's text must match to be written
+	yes        bool   // Skip the confirmation prompt for large runs
+	first      int64  // Offset of the first address to write
+	last       int64  // Offset of the last address to write (-1 for the end)
+	shard      int    // 1-based partition of the address space to write
+	shards     int    // Number of equal partitions -shard picks from
+	noHeader   bool   // Leave out the csv and tsv header row
+	overwrite  bool   // Replace an existing output file
+	group      string // Head each block of this prefix with a comment (e.g. /24)
+	rate       int    // Maximum addresses written per second (0 means unlimited)
+	maxLines   int    // Rotate to a new numbered file after this many addresses
+	reverse    bool   // Write addresses in descending order
+	boundaries bool   // Write only the first and last address of each block
+	lastOctet  string // Comma-separated final octets to keep (IPv4 only)
+	template   string // Pattern for the default filename, e.g. scan_{cidr}_{date}
+	archive    string // Tar.gz file in the output directory to hold -split files
+	strict     bool   // Reject CIDRs with host bits set instead of warning
+	sep        string // Separator after each address in line formats
+	trimSep    bool   // Only separate addresses, without one after the last
+	maskFormat string // Mask written after each txt address: none, netmask or wildcard
+	linePrefix string // Written before each address in the line formats
+	lineSuffix string // Written after each address, before the separator
+	mapped     bool   // Write IPv4 addresses as IPv4-mapped IPv6 (::ffff:a.b.c.d)
+	listName   string // Prefix-list name for -format cisco and juniper
+	aggregate  bool   // Write runs of addresses as their covering CIDRs, not hosts
+	wrap       int    // Line width of -format base64 (0 writes one line)
+	wrapSet    bool   // Whether -wrap was given, on the command line or in the config file
+	geoDB      string // Comma-separated MaxMind DB files for -format enriched
+
+	resolve        bool          // Annotate each address with its reverse DNS name
+	resolveWorkers int           // Reverse DNS lookups run at once
+	resolveTimeout time.Duration // Bound on each reverse DNS lookup
+
+	approx bool    // Deduplicate with a Bloom filter instead of an exact set
+	fpRate float64 // Target false-positive rate of -dedupe-approx
+	jitter float64 // Random variance of each -rate delay, as a fraction of it
+
+	timeout time.Duration // Stop generating after this long (0 means no limit)
+
+	postURL    string  // Collector to POST the output to instead of writing a file
+	postHeader headers // Extra request headers for -post-url, "Name: value"
+
+	geo geoDBs // Databases opened from geoDB once for the whole run
+}
+
+// target is one block of addresses to enumerate, either a CIDR network or
+// an inclusive start-end range
+type target struct {
+	ipnet *net.IPNet   // CIDR network, nil for start-end ranges
+	span  iplist.Range // Addresses covered by the target
 }
 
 // main is the entry point of the application
@@ -23,125 +163,2233 @@ func main() {
 	// Parse command line flags and get configuration
 	config := parseFlags()
 
+	// Cancel generation on Ctrl-C so the output is closed cleanly; a second
+	// Ctrl-C kills the process as usual
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	// Generate IPs and handle any errors
-	if err := generateIPs(config); err != nil {
-		fmt.Printf("Fatal error: %v\n", err)
+	if err := generateIPsCtx(ctx, config); err != nil {
+		if errors.Is(err, context.Canceled) {
+			fmt.Fprintln(os.Stderr, "Interrupted: output holds the IPs generated so far")
+			os.Exit(130)
+		}
+		fmt.Fprintf(os.Stderr, "Fatal error: %v\n", err)
 		os.Exit(1)
 	}
 }
 
 // parseFlags processes command line arguments and returns a Config struct
 func parseFlags() *Config {
+	config, showVersion, err := parseArgs(flag.CommandLine, os.Args[1:])
+
+	// Reporting the version needs no other flags
+	if showVersion {
+		fmt.Println(versionString())
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		fmt.Println("Usage:")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	return config
+}
+
+// parseArgs defines every flag on fs, parses args with them and checks the
+// result, reporting whether -version was given. The environment and any
+// -config file fill in settings the arguments leave out.
+func parseArgs(fs *flag.FlagSet, args []string) (*Config, bool, error) {
 	config := &Config{}
 
 	// Define command line flags
-	flag.StringVar(&config.cidr, "cidr", "", "CIDR range (e.g., 192.168.1.0/24)")
-	flag.StringVar(&config.outputDir, "output", "", "Output directory path")
-	flag.StringVar(&config.filename, "filename", "", "Custom filename (optional)")
+	fs.StringVar(&config.cidr, "cidr", "", "CIDR range or comma-separated list (e.g., 192.168.1.0/24,10.0.0.0/28 or 2001:db8::/120)")
+	fs.StringVar(&config.cidrFile, "cidr-file", "", "File of CIDR ranges, one per line (# comments and blank lines ignored)")
+	fs.StringVar(&config.ipRange, "range", "", "Inclusive IP range or comma-separated list (e.g., 192.168.1.10-192.168.1.200)")
+	fs.StringVar(&config.outputDir, "output", "", "Output directory path (\"-\" writes to stdout)")
+	fs.StringVar(&config.filename, "filename", "", "Custom filename (optional)")
+	fs.StringVar(&config.template, "output-template", "", "Pattern for the filename when -filename isn't given, with {cidr}, {date}, {time} and {count} placeholders (e.g., scan_{cidr}_{date}_{count}.txt)")
+	fs.BoolVar(&config.noMkdir, "no-mkdir", false, "Fail if the output directory doesn't exist instead of creating it")
+	fs.BoolVar(&config.noTime, "no-timestamp", false, "Leave the timestamp out of the default filename so reruns overwrite the same file")
+	fs.BoolVar(&config.dedupe, "dedupe", false, "Skip duplicate IPs from overlapping CIDR ranges")
+	fs.BoolVar(&config.sort, "sort", false, "Merge all ranges into one numerically ascending stream without duplicates, however they overlap or are ordered")
+	fs.BoolVar(&config.approx, "dedupe-approx", false, "Skip duplicate IPs using a Bloom filter of bounded memory, which may also drop a few unique IPs")
+	fs.Float64Var(&config.fpRate, "dedupe-fp-rate", 0.001, "Target false-positive rate of -dedupe-approx, the share of unique IPs wrongly dropped")
+	fs.StringVar(&config.dedupeFile, "dedupe-against", "", "Skip IPs already listed in this file, e.g. the -append target from earlier runs (missing file means none)")
+	fs.BoolVar(&config.strict, "strict", false, "Reject CIDR ranges with host bits set (e.g., 192.168.1.5/24) instead of warning")
+	fs.StringVar(&config.exclude, "exclude", "", "CIDR range or comma-separated list to omit (e.g., 192.168.1.0/28); one outside every range is only warned about unless -strict is set")
+	fs.StringVar(&config.exclFile, "exclude-file", "", "File of CIDR ranges to omit, one per line (# comments allowed), added to any -exclude ranges")
+	fs.BoolVar(&config.stdout, "stdout", false, "Write IPs to stdout instead of a file (status goes to stderr)")
+	fs.BoolVar(&config.tee, "tee", false, "Also write IPs to stdout while writing the output file (status goes to stderr)")
+	fs.BoolVar(&config.usable, "usable", false, "Omit the network and broadcast address of each IPv4 range")
+	fs.BoolVar(&config.publicOnly, "public-only", false, "Omit private, loopback, link-local, multicast and other reserved addresses")
+	fs.StringVar(&config.match, "match", "", `Only write IPs whose text matches this regexp (e.g. "\.1$"); every IP in the ranges is still visited, so this is slower than -last-octet`)
+	fs.IntVar(&config.step, "step", 1, "Write every Nth IP (e.g., 4 for every 4th host)")
+	fs.IntVar(&config.rate, "rate", 0, "Write at most this many IPs per second, e.g. to pace a downstream scanner (0 means unlimited)")
+	fs.DurationVar(&config.timeout, "timeout", 0, "Stop generating after this long, e.g. 30s, keeping what was written so far (0 means no limit)")
+	fs.Float64Var(&config.jitter, "jitter", 0, "Vary each -rate delay randomly by up to this fraction of it, e.g. 0.5 for ±50% (no effect without -rate; reproducible with -seed)")
+	fs.IntVar(&config.limit, "limit", 0, "Stop after writing this many IPs (0 means no limit)")
+	fs.Int64Var(&config.first, "first", 0, "0-based offset of the first IP to write, counted across all ranges")
+	fs.Int64Var(&config.last, "last", -1, "0-based offset of the last IP to write, counted across all ranges (-1 means the end)")
+	fs.IntVar(&config.shard, "shard", 0, "Write only this 1-based partition of the -shards equal, contiguous partitions of all ranges, for splitting a run across machines")
+	fs.IntVar(&config.shards, "shards", 0, "Number of partitions for -shard")
+	fs.IntVar(&config.sample, "sample", 0, "Write N distinct IPs chosen at random instead of the full range")
+	fs.Int64Var(&config.seed, "seed", 0, "Random seed for reproducible -sample, -shuffle, -shuffle-hosts and -jitter, alone or combined (0 picks one from the clock and reports it in the summary)")
+	fs.StringVar(&config.lastOctet, "last-octet", "", "Write only IPv4 addresses whose last octet is in this comma-separated list (e.g., 1,10,254 for common gateways)")
+	fs.BoolVar(&config.boundaries, "boundaries", false, "Write only the network and broadcast (first and last) address of each range or -split subnet")
+	fs.BoolVar(&config.reverse, "reverse", false, "Write IPs in descending order, highest address of the last range first")
+	fs.BoolVar(&config.shuffle, "shuffle", false, "Write IPs in random order (holds the whole range in memory)")
+	fs.BoolVar(&config.shuffleIPs, "shuffle-hosts", false, "Write each /24 (/120 for IPv6) in order but its hosts in random order, holding only 256 IPs at a time")
+	fs.IntVar(&config.shuffleMax, "shuffle-max", 1<<20, "Largest number of IPs -shuffle will hold in memory")
+	fs.IntVar(&config.workers, "workers", 1, "Number of goroutines to split each range across (txt format only)")
+	fs.BoolVar(&config.force, "force", false, "Allow generating more than 1048576 IPs")
+	fs.BoolVar(&config.yes, "yes", false, "Don't ask for confirmation before generating more than 100000 IPs from a terminal")
+	fs.BoolVar(&config.quiet, "quiet", false, "Suppress progress, warnings and the execution summary")
+	fs.BoolVar(&config.noProgress, "no-progress", false, "Don't show progress while generating (a bar on a terminal, periodic lines otherwise)")
+	fs.BoolVar(&config.logJSON, "log-json", false, "Write progress, warnings and the execution summary to stderr as JSON lines")
+	fs.StringVar(&config.format, "format", "txt", "Output format: txt, json, jsonl (one object per line), csv, tsv (ip, integer, hex and CIDR columns), int (decimal integer per line), hex, binary (packed 4- or 16-byte records), range (contiguous runs as start-end lines), nmap (runs as nmap octet ranges, e.g. 10.0.0-3.0-255), cidr (each IP as a /32 or /128 host route), cisco (ip prefix-list entries), juniper (set policy-options prefix-list lines), base64 (binary records base64-encoded in wrapped lines) or enriched (ip,asn,country rows from -geo-db)")
+	fs.StringVar(&config.geoDB, "geo-db", "", "MaxMind DB (.mmdb) file, or comma-separated files such as an ASN and a country database, to look up for -format enriched")
+	fs.IntVar(&config.wrap, "wrap", 76, "Line width of -format base64 output (0 writes it all on one line)")
+	fs.StringVar(&config.listName, "list-name", "IP-LIST", "Prefix-list name used by -format cisco and juniper")
+	fs.BoolVar(&config.aggregate, "aggregate", false, "With -format cidr, cisco or juniper, write each run of consecutive IPs as its covering CIDRs instead of one host route per IP")
+	fs.BoolVar(&config.hexPrefix, "hex-prefix", false, "Prefix -format hex and tsv hex values with 0x")
+	fs.BoolVar(&config.noHeader, "no-header", false, "Leave out the header row of csv and tsv output")
+	fs.StringVar(&config.sep, "sep", "", `Separator after each IP in txt, int and hex output, with escapes (e.g., \r\n, ",", " "; default newline)`)
+	fs.BoolVar(&config.trimSep, "no-trailing-sep", false, "Write -sep only between IPs, not after the last one")
+	fs.StringVar(&config.postURL, "post-url", "", "POST the output to this HTTP endpoint as it is generated instead of writing a file")
+	fs.Var(&config.postHeader, "post-header", "Header to send with -post-url, as \"Name: value\" (repeatable, e.g. for Authorization)")
+	fs.BoolVar(&config.mapped, "mapped", false, "Write IPv4 addresses in IPv4-mapped IPv6 form, e.g. ::ffff:192.168.1.1 (txt, json and csv formats)")
+	fs.StringVar(&config.maskFormat, "mask-format", "none", "Mask to write after each IP in txt output: none, netmask (e.g., 255.255.255.0) or wildcard (e.g., 0.0.0.255)")
+	fs.StringVar(&config.linePrefix, "line-prefix", "", "Text to write before each IP in txt, int and hex output (e.g., \"allow \")")
+	fs.StringVar(&config.lineSuffix, "line-suffix", "", "Text to write after each IP in txt, int and hex output (e.g., \"/32\" for route tables or \";\")")
+	fs.BoolVar(&config.count, "count", false, "Print the number of IPs in the range without writing a file")
+	fs.BoolVar(&config.estimate, "estimate", false, "Print the number of IPs and estimated output size for the chosen format without writing anything")
+	fs.BoolVar(&config.validate, "validate-only", false, "Check the syntax of every -cidr, -cidr-file and -range entry, reporting all invalid ones with their line numbers, and exit non-zero if any are invalid; nothing is generated or created")
+	fs.StringVar(&config.group, "group", "", "Head each block of this prefix length with a # comment and separate blocks with a blank line (e.g., /24)")
+	fs.StringVar(&config.split, "split", "", "Write one file per subnet of this prefix length (e.g., /24)")
+	fs.IntVar(&config.splitJobs, "parallel-files", 1, "Write up to N -split files at once, each with its own writer (at most 64)")
+	fs.StringVar(&config.archive, "archive", "", "With -split, write the subnet files as entries of this .tar.gz in the output directory instead of loose files")
+	fs.IntVar(&config.maxLines, "max-lines", 0, "Rotate to a new numbered file (e.g., list.002.txt) after this many IPs (0 means one file)")
+	fs.BoolVar(&config.overwrite, "overwrite", false, "Replace the output file if it already exists")
+	fs.BoolVar(&config.append, "append", false, "Append to the output file instead of overwriting it (not for json or csv)")
+	fs.BoolVar(&config.gzip, "gzip", false, "Gzip-compress the output and append .gz to the filename")
+	fs.BoolVar(&config.checksum, "checksum", false, "Write the SHA-256 of each output file to a .sha256 sidecar next to it")
+	fs.StringVar(&config.metrics, "metrics-file", "", "Write run metrics (ip_list_generated_ips, duration, rate, skipped counts) to this file in Prometheus text format, e.g. for a node_exporter textfile collector")
+	fs.StringVar(&config.checkpoint, "checkpoint", "", "Save progress to this file while generating; rerunning the same command resumes an interrupted run, appending to its output (removed once the run completes)")
+	fs.StringVar(&config.report, "report", "", "Write a JSON report of the run (inputs, format, counts, skipped IPs, duration, speed, output file and checksum) to this file")
+	fs.StringVar(&config.subtract, "subtract", "", "CIDR range or comma-separated list to remove from the -cidr ranges, printing the minimal CIDRs left instead of IPs (e.g., 10.1.0.0/16)")
+	fs.StringVar(&config.links, "links", "", "Print each /31 or /30 link subnet of the ranges as its pair of host IPs, \"a <-> b\" (/127 or /126 for IPv6)")
+	fs.StringVar(&config.merge, "merge", "", "Combine these comma-separated IP list files or globs (e.g., 'shard_*.txt') into one numerically sorted, deduplicated output")
+	fs.StringVar(&config.summarize, "summarize", "", "Read IPs from this file (\"-\" for stdin) and print the fewest CIDR ranges covering them")
+	fs.BoolVar(&config.resolve, "resolve", false, "Write each IP with its reverse DNS hostname, tab-separated (txt format only, very slow for large ranges)")
+	fs.IntVar(&config.resolveWorkers, "resolve-workers", 16, "Number of reverse DNS lookups to run at once with -resolve")
+	fs.DurationVar(&config.resolveTimeout, "resolve-timeout", 2*time.Second, "Time limit for each reverse DNS lookup with -resolve")
+
+	var showVersion bool
+	fs.BoolVar(&showVersion, "version", false, "Print the version and build information and exit")
+	var configFile string
+	fs.StringVar(&configFile, "config", "", "JSON file of settings keyed by flag name (e.g., {\"format\": \"csv\", \"limit\": 500}); flags on the command line take precedence")
 
 	// Parse the flags
-	flag.Parse()
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+	if showVersion {
+		return nil, true, nil
+	}
+
+	// Fill in settings from the environment and then the config file that
+	// weren't given as flags
+	if err := applyEnv(fs); err != nil {
+		return nil, false, err
+	}
+	if configFile != "" {
+		if err := applyConfigFile(configFile, fs); err != nil {
+			return nil, false, err
+		}
+	}
+
+	// -wrap only means something for base64, so giving it at all for
+	// another format is a mistake, even at its default width
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "wrap" {
+			config.wrapSet = true
+		}
+	})
 
 	// Validate required flags
-	if config.cidr == "" {
-		fmt.Println("Error: CIDR range is required")
-		fmt.Println("Usage:")
-		flag.PrintDefaults()
-		os.Exit(1)
+	if config.cidr == "" && config.ipRange == "" && config.cidrFile == "" && config.summarize == "" && config.merge == "" {
+		return nil, false, fmt.Errorf("CIDR range, CIDR file, IP range, -summarize or -merge input is required")
 	}
 
-	return config
+	// Validate output format
+	if _, ok := formatExtensions[config.format]; !ok {
+		return nil, false, fmt.Errorf("unknown format %q", config.format)
+	}
+
+	// Validate the mask format
+	if config.maskFormat != "none" && config.maskFormat != "netmask" && config.maskFormat != "wildcard" {
+		return nil, false, fmt.Errorf("unknown mask format %q", config.maskFormat)
+	}
+
+	// Validate the false-positive rate, which has to leave room for both
+	// hits and misses
+	if config.fpRate <= 0 || config.fpRate >= 1 {
+		return nil, false, fmt.Errorf("-dedupe-fp-rate must be between 0 and 1, got %v", config.fpRate)
+	}
+
+	// Validate the filename template
+	if err := checkTemplate(config.template); err != nil {
+		return nil, false, err
+	}
+
+	// Interpret escapes in the separator, so \r\n can be typed as is
+	if config.sep == "" {
+		config.sep = `\n`
+	}
+	sep, err := strconv.Unquote(`"` + strings.ReplaceAll(config.sep, `"`, `\"`) + `"`)
+	if err != nil || sep == "" {
+		return nil, false, fmt.Errorf("invalid separator %q", config.sep)
+	}
+	config.sep = sep
+
+	return config, false, nil
+}
+
+// versionString describes this build: the version plus the commit and
+// build date when they were set with -ldflags. Without them, the commit
+// recorded by the Go toolchain is used if there is one.
+func versionString() string {
+	rev, date := commit, buildDate
+	if info, ok := debug.ReadBuildInfo(); ok && rev == "" {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				rev = setting.Value
+			}
+		}
+	}
+
+	s := "ip-list-generator " + version
+	if rev != "" {
+		s += " (commit " + rev
+		if date != "" {
+			s += ", built " + date
+		}
+		s += ")"
+	} else if date != "" {
+		s += " (built " + date + ")"
+	}
+	return s
 }
 
 // generateIPs handles the IP generation and file writing process
 func generateIPs(config *Config) error {
-	// Validate and parse CIDR notation
-	ip, ipnet, err := net.ParseCIDR(config.cidr)
+	return generateIPsCtx(context.Background(), config)
+}
+
+// generateIPsCtx runs generateIPs until ctx is cancelled. Cancellation is
+// checked every progressInterval addresses; the output written so far is
+// closed out so it stays valid, a partial summary is printed and ctx's
+// error is returned.
+func generateIPsCtx(ctx context.Context, config *Config) error {
+	return generateIPsTo(ctx, config, os.Stdout, os.Stderr)
+}
+
+// generateIPsTo runs generateIPsCtx with the process's standard streams
+// replaced by stdout and stderr, so the address stream and status output
+// can be captured by any io.Writer
+func generateIPsTo(ctx context.Context, config *Config, stdout, stderr io.Writer) error {
+	// Status messages go to stdout unless the IP list itself is going there,
+	// directly or through -tee, in which case they move to stderr so they
+	// don't mix with the addresses. JSON logs always go to stderr, for log
+	// collectors.
+	toStdout := config.stdout || config.outputDir == "-"
+	logOut := stdout
+	if toStdout || config.tee || config.logJSON {
+		logOut = stderr
+	}
+
+	// Quiet mode drops warnings, progress and the summary; fatal errors are
+	// still reported by main
+	if config.quiet {
+		logOut = io.Discard
+	}
+
+	// Validation only checks the inputs, creating and writing nothing
+	if config.validate {
+		return validateInputs(config, stdout, logOut)
+	}
+
+	// Enrichment looks every address up in local MaxMind databases, read
+	// once here and shared by every output file
+	if (config.format == "enriched") != (config.geoDB != "") {
+		return fmt.Errorf("-format enriched and -geo-db must be used together")
+	}
+	if config.geoDB != "" {
+		geo, err := openGeoDBs(config.geoDB)
+		if err != nil {
+			return err
+		}
+		config.geo = geo
+	}
+
+	// Summarizing turns a list of IPs back into CIDRs instead of generating
+	if config.summarize != "" {
+		return summarizeIPs(config, stdout)
+	}
+
+	// Merging reads earlier output back in rather than generating
+	if config.merge != "" {
+		return mergeFiles(config, stdout, logOut)
+	}
+
+	// Validate and parse CIDR notation and IP ranges
+	targets, loose, err := parseTargets(config)
 	if err != nil {
-		return fmt.Errorf("invalid CIDR format: %v", err)
+		return err
 	}
 
-	// Set default output directory if not specified
-	if config.outputDir == "" {
-		currentDir, err := os.Getwd()
+	// A CIDR with host bits set is usually a typo in the address or the
+	// prefix, so point out the network that will actually be enumerated
+	for _, note := range loose {
+		if config.strict {
+			return fmt.Errorf("%s; pass the network address or drop -strict", note)
+		}
+		warn(logOut, config, "%s", note)
+	}
+
+	// Subtraction is prefix arithmetic, so nothing is enumerated either
+	if config.subtract != "" {
+		return subtractCIDRs(config, targets, stdout)
+	}
+
+	// Link pairs are subnet iteration too, two addresses to a line
+	if config.links != "" {
+		return printLinks(config, targets, stdout)
+	}
+
+	// Sorting merges the targets themselves, so overlaps never reach the
+	// enumeration and nothing has to remember what was written
+	if config.sort {
+		if config.usable {
+			return fmt.Errorf("-sort merges the ranges, so there are no per-range network and broadcast addresses for -usable to skip")
+		}
+		if config.reverse || config.shuffle || config.shuffleIPs {
+			return fmt.Errorf("-sort writes IPs in ascending order and can't be combined with -reverse, -shuffle or -shuffle-hosts")
+		}
+		targets = sortTargets(targets)
+	}
+
+	// Count mode only reports sizes, so nothing is created or enumerated
+	if config.count {
+		printCounts(stdout, config, targets)
+		return nil
+	}
+
+	// Refuse IPv6 ranges too large to write out
+	for _, t := range targets {
+		if err := iplist.CheckRangeSize(t.span); err != nil {
+			return err
+		}
+	}
+
+	// Parse exclusions. One that misses every target range is harmless but
+	// most likely a typo, so it is pointed out, or refused with -strict.
+	var excludes []*net.IPNet
+	if config.exclude != "" {
+		excludes, _, err = parseCIDRList(config.exclude)
 		if err != nil {
-			return fmt.Errorf("failed to get current directory: %v", err)
+			return fmt.Errorf("invalid exclusion: %w", err)
+		}
+	}
+	if config.exclFile != "" {
+		networks, _, err := readCIDRFile(config.exclFile)
+		if err != nil {
+			return fmt.Errorf("-exclude-file: %w", err)
+		}
+		excludes = append(excludes, networks...)
+	}
+	for _, ex := range excludes {
+		if !overlapsAny(ex, targets) {
+			if config.strict {
+				return fmt.Errorf("exclusion %s is outside every target range; drop it or -strict", ex)
+			}
+			warn(logOut, config, "exclusion %s is outside every target range and excludes nothing", ex)
 		}
-		config.outputDir = currentDir
 	}
 
-	// Create output directory if it doesn't exist
-	if err := os.MkdirAll(config.outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory: %v", err)
+	// JSON arrays and CSV/TSV headers can't be continued by appending
+	// another run
+	if config.append && (config.format == "json" || config.format == "csv" || config.format == "tsv" || config.format == "base64" || config.format == "enriched") {
+		return fmt.Errorf("-append is not supported with -format %s", config.format)
 	}
 
-	// Generate default filename if not provided
-	if config.filename == "" {
-		timestamp := time.Now().Format("20060102_150405")
-		sanitizedCIDR := strings.Replace(config.cidr, "/", "_", -1)
-		sanitizedCIDR = strings.Replace(sanitizedCIDR, ".", "-", -1)
-		config.filename = fmt.Sprintf("ip_list_%s_%s.txt", sanitizedCIDR, timestamp)
+	// Packed records carry no length, so a reader can only split them if
+	// every address has the same size. A CIDR file of only comments leaves
+	// no targets, and an empty list is written as such.
+	if (config.format == "binary" || config.format == "base64") && len(targets) > 0 {
+		for _, t := range targets[1:] {
+			if t.span.IsIPv4() != targets[0].span.IsIPv4() {
+				return fmt.Errorf("-format %s can't mix IPv4 and IPv6 targets (%s and %s)", config.format, targets[0], t)
+			}
+		}
+	}
+	if config.wrap < 0 {
+		return fmt.Errorf("-wrap must not be negative")
+	}
+	if config.wrapSet && config.format != "base64" {
+		return fmt.Errorf("-wrap only applies to -format base64")
 	}
 
-	// Ensure filename has .txt extension
-	if !strings.HasSuffix(config.filename, ".txt") {
-		config.filename += ".txt"
+	// The sidecar describes a whole file, which this run only produces
+	// when it writes one from scratch
+	if config.checksum && (toStdout || config.append) {
+		return fmt.Errorf("-checksum needs an output file and can't be combined with stdout or -append")
 	}
 
-	// Construct full file path
-	filepath := filepath.Join(config.outputDir, config.filename)
+	// The exact and approximate modes are two ways of doing the same thing
+	if config.dedupe && config.approx {
+		return fmt.Errorf("-dedupe and -dedupe-approx can't be combined; use -dedupe-approx when the exact set won't fit in memory")
+	}
 
-	// Create and open output file
-	file, err := os.Create(filepath)
-	if err != nil {
-		return fmt.Errorf("error creating file: %v", err)
+	// Workers write plain lines to temp files that are joined afterwards,
+	// which only works when each address is rendered independently
+	if config.workers > 1 && (config.format != "txt" || config.dedupe || config.approx || config.limit > 0 || config.sample > 0 || config.shuffle || config.resolve) {
+		return fmt.Errorf("-workers only supports txt format without -dedupe, -dedupe-approx, -limit, -sample, -shuffle or -resolve")
 	}
-	defer file.Close()
 
-	// Create buffered writer for better performance
-	writer := bufio.NewWriter(file)
-	defer writer.Flush()
+	// Only the line formats have a separator to change, and workers and
+	// appended runs assume every address ends its own line
+	customSep := config.sep != "\n" || config.trimSep
+	if customSep && config.format != "txt" && config.format != "int" && config.format != "hex" {
+		return fmt.Errorf("-sep and -no-trailing-sep only apply to txt, int and hex formats")
+	}
+	if customSep && (config.workers > 1 || config.append || config.resolve) {
+		return fmt.Errorf("-sep and -no-trailing-sep can't be combined with -workers, -append or -resolve")
+	}
 
-	// Initialize progress tracking
-	count := 0
-	startTime := time.Now()
+	// Wrapping each address likewise goes through the line formats'
+	// records, which workers and -resolve write around
+	wrapped := config.linePrefix != "" || config.lineSuffix != ""
+	if wrapped && config.format != "txt" && config.format != "int" && config.format != "hex" {
+		return fmt.Errorf("-line-prefix and -line-suffix only apply to txt, int and hex formats")
+	}
+	if wrapped && (config.workers > 1 || config.resolve) {
+		return fmt.Errorf("-line-prefix and -line-suffix can't be combined with -workers or -resolve")
+	}
+
+	// Prefix-list names end up as a single word of router configuration
+	if config.listName == "" || strings.ContainsAny(config.listName, " \t\r\n\"") {
+		return fmt.Errorf("-list-name must be a non-empty name without spaces or quotes, got %q", config.listName)
+	}
+	if config.aggregate && config.format != "cidr" && config.format != "cisco" && config.format != "juniper" {
+		return fmt.Errorf("-aggregate only applies to cidr, cisco and juniper formats")
+	}
+
+	// Masks come from the network of each address as the txt formatter
+	// writes it
+	if config.maskFormat != "none" && (config.format != "txt" || config.workers > 1 || config.resolve) {
+		return fmt.Errorf("-mask-format only applies to txt format without -workers or -resolve")
+	}
+
+	// The mapped form is an IPv6 spelling of an IPv4 address, for the
+	// formats that write addresses as text and nothing else
+	if config.mapped {
+		if config.format != "txt" && config.format != "json" && config.format != "csv" {
+			return fmt.Errorf("-mapped only applies to txt, json and csv formats")
+		}
+		if config.workers > 1 || config.resolve || config.maskFormat != "none" {
+			return fmt.Errorf("-mapped can't be combined with -workers, -resolve or -mask-format")
+		}
+		for _, t := range targets {
+			if !t.span.IsIPv4() {
+				return fmt.Errorf("-mapped only applies to IPv4, not %s", t)
+			}
+		}
+	}
+
+	// Pacing happens as each address is written, which workers bypass
+	if config.rate < 0 {
+		return fmt.Errorf("-rate must not be negative")
+	}
+	if config.rate > 0 && config.workers > 1 {
+		return fmt.Errorf("-rate can't be combined with -workers")
+	}
+	if config.jitter < 0 || config.jitter > 1 {
+		return fmt.Errorf("-jitter must be between 0 and 1, got %v", config.jitter)
+	}
+	if config.jitter > 0 && config.rate == 0 {
+		warn(logOut, config, "-jitter has no effect without -rate")
+	}
 
-	// Generate and write IPs
-	for ip := ip.Mask(ipnet.Mask); ipnet.Contains(ip); inc(ip) {
-		if _, err := writer.WriteString(ip.String() + "\n"); err != nil {
-			return fmt.Errorf("error writing to file: %v", err)
+	// Reverse DNS annotates plain lines and runs its own lookup pool
+	if config.resolve {
+		if config.format != "txt" {
+			return fmt.Errorf("-resolve only supports txt format")
 		}
-		count++
+		if config.resolveWorkers < 1 {
+			return fmt.Errorf("-resolve-workers must be at least 1")
+		}
+		warn(logOut, config, "-resolve performs a reverse DNS lookup for every IP, which is much slower than plain generation for large ranges")
+	}
 
-		// Show progress for large ranges
-		if count%10000 == 0 {
-			fmt.Printf("Generated %d IPs...\n", count)
+	// Stepping skips addresses by position, which a random or chunked walk
+	// would lose track of
+	if config.step < 1 {
+		return fmt.Errorf("-step must be at least 1")
+	}
+	if config.step > 1 && (config.workers > 1 || config.sample > 0 || config.shuffle) {
+		return fmt.Errorf("-step can't be combined with -workers, -sample or -shuffle")
+	}
+
+	// Splitting writes each subnet of the given size to its own file, so
+	// every target must be a CIDR network at least that large
+	splitPrefix := 0
+	if config.split != "" {
+		splitPrefix, err = strconv.Atoi(strings.TrimPrefix(config.split, "/"))
+		if err != nil {
+			return fmt.Errorf("invalid -split prefix %q", config.split)
+		}
+		if toStdout || config.workers > 1 || config.sample > 0 || config.shuffle {
+			return fmt.Errorf("-split writes files in order and can't be combined with stdout, -workers, -sample or -shuffle")
+		}
+		for _, t := range targets {
+			if t.ipnet == nil {
+				return fmt.Errorf("-split requires CIDR ranges, not IP range %s", t)
+			}
+			if err := iplist.CheckSubnetPrefix(t.ipnet, splitPrefix); err != nil {
+				return fmt.Errorf("invalid -split prefix: %v", err)
+			}
 		}
 	}
 
-	// Calculate execution time
-	duration := time.Since(startTime)
+	// Files written side by side each count on their own, so nothing that
+	// spans files or feeds one stream can be shared between them
+	if config.splitJobs < 1 {
+		return fmt.Errorf("-parallel-files must be at least 1")
+	}
+	if config.splitJobs > 1 {
+		if splitPrefix == 0 {
+			return fmt.Errorf("-parallel-files requires -split")
+		}
+		if config.archive != "" || config.dedupe || config.approx || config.limit > 0 || config.rate > 0 || config.shuffleIPs {
+			return fmt.Errorf("-parallel-files can't be combined with -archive, -dedupe, -dedupe-approx, -limit, -rate or -shuffle-hosts")
+		}
+		if config.splitJobs > maxParallelFiles {
+			warn(logOut, config, "-parallel-files %d is more than %d; writing %d files at once", config.splitJobs, maxParallelFiles, maxParallelFiles)
+			config.splitJobs = maxParallelFiles
+		}
+	}
 
-	// Print summary
-	fmt.Printf("\nExecution Summary:\n")
-	fmt.Printf("----------------\n")
-	fmt.Printf("CIDR Range: %s\n", config.cidr)
-	fmt.Printf("Total IPs Generated: %d\n", count)
-	fmt.Printf("Time Taken: %v\n", duration)
-	fmt.Printf("Output File: %s\n", filepath)
-	fmt.Printf("Average Speed: %.2f IPs/second\n", float64(count)/duration.Seconds())
+	// Descending order walks the targets and each span backwards, which
+	// chunked, per-subnet and random walks don't do
+	if config.reverse && (config.workers > 1 || splitPrefix > 0 || config.shuffle) {
+		return fmt.Errorf("-reverse can't be combined with -workers, -split or -shuffle")
+	}
 
-	return nil
-}
+	// Posting streams one body, so there's no file to name, split, rotate,
+	// add to or checksum
+	posting := config.postURL != ""
+	if config.tee && (toStdout || posting || splitPrefix > 0 || config.maxLines > 0) {
+		return fmt.Errorf("-tee copies a single output file to stdout and can't be combined with stdout, -post-url, -split or -max-lines")
+	}
+	if posting && (toStdout || splitPrefix > 0 || config.maxLines > 0 || config.append || config.checksum) {
+		return fmt.Errorf("-post-url can't be combined with stdout, -split, -max-lines, -append or -checksum")
+	}
+
+	// Boundaries are the two ends of each block, which the host filters,
+	// partial spans and random or chunked walks don't leave intact
+	if config.boundaries && (config.usable || config.step > 1 || config.first != 0 || config.last >= 0 || config.shards > 0 || config.workers > 1 || config.sample > 0 || config.shuffle) {
+		return fmt.Errorf("-boundaries can't be combined with -usable, -step, -first, -last, -shard, -workers, -sample or -shuffle")
+	}
+
+	// The pattern is compiled once and tried against every address, which
+	// visits the whole range however few addresses match
+	var match *regexp.Regexp
+	if config.match != "" {
+		if match, err = regexp.Compile(config.match); err != nil {
+			return fmt.Errorf("invalid -match pattern: %v", err)
+		}
+	}
+
+	// Matching last octets are built block by block, for IPv4 only, and
+	// other ways of choosing addresses would fight over which to take
+	var octets []uint32
+	if config.lastOctet != "" {
+		if octets, err = parseOctets(config.lastOctet); err != nil {
+			return err
+		}
+		for _, t := range targets {
+			if !t.span.IsIPv4() {
+				return fmt.Errorf("-last-octet only applies to IPv4, not %s", t)
+			}
+		}
+		if config.step > 1 || config.boundaries || config.workers > 1 || config.sample > 0 || config.shuffle {
+			return fmt.Errorf("-last-octet can't be combined with -step, -boundaries, -workers, -sample or -shuffle")
+		}
+	}
+
+	// Host shuffling walks every address of each block itself, so it takes
+	// no other way of picking or ordering them
+	if config.shuffleIPs && (config.step > 1 || config.boundaries || octets != nil || config.workers > 1 || config.sample > 0 || config.shuffle) {
+		return fmt.Errorf("-shuffle-hosts can't be combined with -step, -boundaries, -last-octet, -workers, -sample or -shuffle")
+	}
+
+	// Rotation opens its numbered files one after another during a plain
+	// sequential run into the output directory
+	if config.maxLines < 0 {
+		return fmt.Errorf("-max-lines must not be negative")
+	}
+	if config.maxLines > 0 && (toStdout || splitPrefix > 0 || config.workers > 1 || config.append) {
+		return fmt.Errorf("-max-lines can't be combined with stdout, -split, -workers or -append")
+	}
+
+	// An archive bundles split files, and is already compressed and
+	// written fresh
+	if config.archive != "" && splitPrefix == 0 {
+		return fmt.Errorf("-archive requires -split")
+	}
+	if config.archive != "" && (config.gzip || config.append || config.checksum) {
+		return fmt.Errorf("-archive can't be combined with -gzip, -append or -checksum")
+	}
+
+	// A templated name covers the one output file, and a count in it can
+	// only be filled in once that file is complete
+	if config.template != "" && splitPrefix > 0 {
+		return fmt.Errorf("-output-template can't be combined with -split")
+	}
+	if strings.Contains(config.template, "{count}") && (config.append || config.maxLines > 0) {
+		return fmt.Errorf("-output-template {count} can't be combined with -append or -max-lines")
+	}
+
+	// Grouping heads each block of a given size with a comment line, which
+	// only reads well in plain line formats written in ascending order
+	groupPrefix := 0
+	if config.group != "" {
+		groupPrefix, err = strconv.Atoi(strings.TrimPrefix(config.group, "/"))
+		if err != nil {
+			return fmt.Errorf("invalid -group prefix %q", config.group)
+		}
+		if (config.format != "txt" && config.format != "int" && config.format != "hex") || customSep || config.resolve {
+			return fmt.Errorf("-group only supports txt, int and hex formats without -sep, -no-trailing-sep or -resolve")
+		}
+		if config.workers > 1 || config.shuffle {
+			return fmt.Errorf("-group needs addresses in order and can't be combined with -workers or -shuffle")
+		}
+		for _, t := range targets {
+			if t.ipnet != nil {
+				if err := iplist.CheckSubnetPrefix(t.ipnet, groupPrefix); err != nil {
+					return fmt.Errorf("invalid -group prefix: %v", err)
+				}
+			} else if bits := len(t.span.First) * 8; groupPrefix < 0 || groupPrefix > bits {
+				return fmt.Errorf("invalid -group prefix: /%d is out of range for %s", groupPrefix, t)
+			}
+		}
+	}
+
+	// A checkpoint picks a file up where an earlier run left it, so each run
+	// has to write the same addresses in the same order, one record after
+	// another with nothing framing them, and nothing can depend on what an
+	// earlier run wrote
+	var resumed *checkpoint
+	if config.checkpoint != "" {
+		if toStdout || posting || splitPrefix > 0 || config.maxLines > 0 || config.gzip || strings.Contains(config.template, "{count}") {
+			return fmt.Errorf("-checkpoint needs a single uncompressed output file and can't be combined with stdout, -post-url, -split, -max-lines, -gzip or -output-template {count}")
+		}
+		if config.workers > 1 || config.sample > 0 || config.shuffle || config.shuffleIPs || config.reverse || config.step > 1 || config.boundaries || octets != nil ||
+			config.first != 0 || config.last >= 0 || config.shards != 0 || config.limit > 0 || config.dedupe || config.approx || config.resolve || groupPrefix > 0 {
+			return fmt.Errorf("-checkpoint resumes in address order and can't be combined with -workers, -sample, -shuffle, -shuffle-hosts, -reverse, -step, -boundaries, -last-octet, -first, -last, -shard, -limit, -dedupe, -dedupe-approx, -resolve or -group")
+		}
+		switch config.format {
+		case "json", "csv", "tsv", "base64", "range", "nmap", "enriched":
+			return fmt.Errorf("-checkpoint can't resume -format %s, which isn't one self-contained record per IP", config.format)
+		}
+		if config.aggregate || config.trimSep {
+			return fmt.Errorf("-checkpoint can't be combined with -aggregate or -no-trailing-sep")
+		}
+		if resumed, err = loadCheckpoint(config.checkpoint); err != nil {
+			return err
+		}
+		if resumed != nil {
+			if err := resumed.matches(config.checkpoint, config, targets); err != nil {
+				return err
+			}
+		}
+	}
+
+	// An offset window narrows the run to the addresses between two
+	// positions in the combined enumeration of every target
+	combined := uint64(0)
+	for _, t := range targets {
+		combined += t.span.Size().Uint64()
+	}
+	first, last := uint64(config.first), combined-1
+	sharded := config.shard != 0 || config.shards != 0
+	windowed := config.first != 0 || config.last >= 0 || sharded
+	if windowed {
+		if config.workers > 1 || config.sample > 0 || config.shuffle || splitPrefix > 0 {
+			return fmt.Errorf("-first, -last and -shard can't be combined with -workers, -sample, -shuffle or -split")
+		}
+	}
+	if sharded {
+		// A shard is the same kind of window, worked out so that the
+		// shards of every node together cover each address exactly once
+		if config.shards < 1 || config.shard < 1 || config.shard > config.shards {
+			return fmt.Errorf("-shard must be between 1 and -shards, got %d of %d", config.shard, config.shards)
+		}
+		if config.first != 0 || config.last >= 0 {
+			return fmt.Errorf("-shard can't be combined with -first or -last")
+		}
+		first, last = shardBounds(combined, config.shard, config.shards)
+		if first > last {
+			warn(logOut, config, "shard %d of %d is empty: the ranges hold only %d IPs", config.shard, config.shards, combined)
+		}
+	} else if windowed {
+		if config.last >= 0 {
+			last = uint64(config.last)
+		}
+		if config.first < 0 || first >= combined {
+			return fmt.Errorf("-first %d is outside the %d IPs available", config.first, combined)
+		}
+		if last >= combined {
+			return fmt.Errorf("-last %d is outside the %d IPs available", config.last, combined)
+		}
+		if first > last {
+			return fmt.Errorf("-first %d is after -last %d", config.first, config.last)
+		}
+	}
+	if resumed != nil {
+		first = resumed.Next
+	}
+	spans := windowSpans(targets, first, last)
+
+	// Sampling picks from the combined address space of every target; asking
+	// for at least that many just produces the full list. With a step only
+	// every Nth address of each target is visited.
+	total := uint64(0)
+	visits := make([]uint64, len(spans))
+	for k, span := range spans {
+		if span.First != nil {
+			size := span.Size().Uint64()
+			if config.boundaries {
+				visits[k] = min(size, 2)
+			} else if octets != nil {
+				visits[k] = countOctets(span, octets)
+			} else {
+				visits[k] = (size + uint64(config.step) - 1) / uint64(config.step)
+			}
+			total += visits[k]
+		}
+	}
+	sampling := config.sample > 0
+	if sampling && uint64(config.sample) >= total {
+		warn(logOut, config, "sample size %d is not smaller than the %d IPs available, writing all of them", config.sample, total)
+		sampling = false
+	}
+
+	// A sample or limit bounds what is written, so plan for that instead
+	// of the whole address space
+	planned := total
+	if sampling {
+		planned = uint64(config.sample)
+	}
+	if config.limit > 0 && uint64(config.limit) < planned {
+		planned = uint64(config.limit)
+	}
+
+	// Estimate mode reports what the run would write, before the size guard
+	// so it can be used to decide whether -force is worth it
+	if config.estimate {
+		printEstimate(stdout, config, spans, visits, planned, total)
+		return nil
+	}
+
+	// Every enriched row is a tree walk per database, so large ranges are
+	// much slower than plain output
+	if config.format == "enriched" && planned > geoWarnIPs {
+		warn(logOut, config, "-format enriched looks up each of the %d IPs in -geo-db, which is slow for large ranges; it is best kept to a /16 or smaller", planned)
+	}
+
+	// Refuse runs big enough to suggest a typo in the prefix length
+	if planned > maxUnforcedIPs && !config.force {
+		return fmt.Errorf("refusing to generate %d IPs (more than %d without confirmation); pass -force to override", planned, maxUnforcedIPs)
+	}
+
+	// Give someone at a terminal a chance to back out of a big run; scripts
+	// don't have a terminal on stdin and aren't asked
+	if planned > confirmThreshold && !config.yes && !config.quiet && isTerminal(os.Stdin) {
+		if !confirm(os.Stdin, stderr, fmt.Sprintf("Generate %d addresses? [y/N] ", planned)) {
+			return fmt.Errorf("generation of %d IPs not confirmed", planned)
+		}
+	}
+
+	rng := newRand(config)
+
+	// Sampling and shuffling both work on offsets into the combined address
+	// space. A sample holds only the chosen offsets, but a full shuffle has
+	// to hold one for every address, so it is capped.
+	var offsets []uint64
+	if sampling {
+		offsets = sampleOffsets(config.sample, total, rng)
+	} else if config.shuffle {
+		if total > uint64(config.shuffleMax) {
+			return fmt.Errorf("-shuffle holds every address in memory (8 bytes each): %d IPs exceeds -shuffle-max %d; raise -shuffle-max if you have about %d MB to spare, or use -sample", total, config.shuffleMax, total*8/(1<<20)+1)
+		}
+		offsets = make([]uint64, total)
+		for i := range offsets {
+			offsets[i] = uint64(i)
+		}
+	}
+	if config.shuffle {
+		rng.Shuffle(len(offsets), func(a, b int) { offsets[a], offsets[b] = offsets[b], offsets[a] })
+	}
+	if config.reverse {
+		// Samples come out ascending, so flip them
+		for a, b := 0, len(offsets)-1; a < b; a, b = a+1, b-1 {
+			offsets[a], offsets[b] = offsets[b], offsets[a]
+		}
+	}
+
+	// Warn about targets that will produce no output at all
+	for _, t := range targets {
+		if exclusion := coveringNetwork(t.span, excludes); exclusion != nil {
+			warn(logOut, config, "%s is fully covered by exclusion %s, no IPs will be generated from it", t, exclusion)
+		}
+	}
+
+	// Resolve the destination: stdout, a single file, or a directory of
+	// per-subnet files
+	path, basePath := "", ""
+	archivePath := filepath.Join(config.outputDir, config.archive)
+	countNamed := false
+	if !toStdout && !posting {
+		if err := prepareOutputDir(config); err != nil {
+			return err
+		}
+		if splitPrefix == 0 {
+			// Timestamped default names are unique per run, so only names
+			// that repeat from run to run can clobber earlier output
+			templated := config.filename == "" && config.template != ""
+			host := config.filename == "" && config.template == "" && singleHost(config) != ""
+			repeatable := config.filename != "" || config.noTime || config.template != "" || host
+			path = filepath.Join(config.outputDir, outputFilename(config))
+			countNamed = templated && strings.Contains(path, "{count}")
+
+			// An existing named pipe is fed under its own name, without the
+			// format extension
+			if fifo := filepath.Join(config.outputDir, config.filename); isNamedPipe(fifo) {
+				path = fifo
+				repeatable = false
+			}
+
+			// Rotated files are numbered from 1 after the base name
+			if config.maxLines > 0 {
+				basePath = path
+				path = rotatedPath(config, basePath, 1)
+			}
+			// A count in the name is only known at the end, so the
+			// clobber check waits until then
+			if repeatable && !countNamed && resumed == nil {
+				if err := checkOverwrite(config, path); err != nil {
+					return err
+				}
+			}
+
+			// Checkpointed output is written in place rather than renamed
+			// at the end, so what the checkpoint records is on disk. A
+			// resumed run carries on in the file it was writing, with
+			// whatever it wrote after the last save cut off.
+			if config.checkpoint != "" {
+				if isNamedPipe(path) {
+					return fmt.Errorf("-checkpoint can't resume output to a named pipe")
+				}
+				if resumed != nil {
+					if repeatable && path != resumed.Output {
+						return fmt.Errorf("checkpoint %s is for output %s, not %s; remove it to start over", config.checkpoint, resumed.Output, path)
+					}
+					path = resumed.Output
+					if err := resumed.resumeOutput(); err != nil {
+						return err
+					}
+				} else if !config.append {
+					if err := checkOverwrite(config, path); err != nil {
+						return err
+					}
+					if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+						return fmt.Errorf("error replacing output file: %v", err)
+					}
+				}
+				config.append = true
+			}
+		}
+	}
+
+	// The time limit covers generation only, not the checks and prompt
+	// before it
+	genCtx := ctx
+	if config.timeout > 0 {
+		var cancel context.CancelFunc
+		genCtx, cancel = context.WithTimeout(ctx, config.timeout)
+		defer cancel()
+	}
 
-// inc increments an IP address by one
-func inc(ip net.IP) {
-	for j := len(ip) - 1; j >= 0; j-- {
-		ip[j]++
-		if ip[j] > 0 {
-			break
+	// Initialize progress tracking
+	g := &generator{
+		ctx:       genCtx,
+		config:    config,
+		targets:   targets,
+		perTarget: make([]int, len(targets)),
+		filters:   &filters{usable: config.usable, excludes: excludes, publicOnly: config.publicOnly, match: match},
+		progress:  &progress{out: logOut, json: config.logJSON, rate: config.rate, total: total, blocks: len(targets), limit: config.limit},
+		limit:     config.limit,
+		step:      uint64(config.step),
+		octets:    octets,
+		group:     groupPrefix,
+		rate:      config.rate,
+		jitter:    config.jitter,
+		maxLines:  config.maxLines,
+		basePath:  basePath,
+	}
+	if offsets != nil {
+		g.progress.total = uint64(len(offsets))
+	}
+	if config.checkpoint != "" {
+		g.checkpoint = newCheckpointer(config.checkpoint, targets, path, config.format, resumed)
+	}
+
+	// A terminal gets one bar redrawn in place; logs and pipes get the
+	// periodic lines, which read better after the fact
+	if config.noProgress {
+		g.progress.out = io.Discard
+	} else if f, ok := logOut.(*os.File); ok && !config.logJSON && isTerminal(f) {
+		g.progress.bar = true
+	}
+	g.rng = rng
+	startTime := time.Now()
+	g.progress.start = startTime
+
+	// Track written addresses when deduplicating overlapping ranges, or
+	// only their Bloom filter bits when an exact set may not fit in memory
+	if config.dedupe {
+		g.filters.seen = make(map[string]struct{})
+	}
+	if config.approx {
+		g.filters.approx = newBloom(g.progress.total, config.fpRate)
+	}
+
+	// Load the earlier list before the output is opened, since it may be
+	// the very file being appended to
+	if config.dedupeFile != "" {
+		g.filters.existing, err = readExisting(config.dedupeFile)
+		if err != nil {
+			return err
 		}
 	}
+
+	// Generate and write IPs: one file per subnet, or a single output
+	// holding a random sample or shuffle of the whole job, or each target
+	// in turn, split across workers if asked
+	var out *output
+	posted := ""
+	if splitPrefix > 0 {
+		if config.archive != "" {
+			if err := checkOverwrite(config, archivePath); err != nil {
+				return err
+			}
+			if g.archive, err = openArchive(archivePath); err != nil {
+				return err
+			}
+			defer g.archive.discard()
+		}
+		err = g.writeSplit(splitPrefix)
+		if g.archive != nil && (err == nil || stopped(err)) {
+			if closeErr := g.archive.close(); closeErr != nil {
+				err = closeErr
+			}
+		}
+	} else {
+		stream := stdout
+		var p *post
+		if posting {
+			if p, err = startPost(ctx, config); err != nil {
+				return err
+			}
+			defer p.abort()
+			stream = p.body
+		}
+		out, err = openOutput(config, path, stream)
+		if err != nil {
+			return err
+		}
+		if posting {
+			out.path = config.postURL
+		}
+		defer func() { g.out.discard() }()
+		if err := g.begin(out); err != nil {
+			return err
+		}
+		if g.checkpoint != nil {
+			if err := g.saveCheckpoint(); err != nil {
+				return err
+			}
+		}
+
+		if offsets != nil {
+			err = g.writeOffsets(offsets)
+		} else {
+			for n := range targets {
+				i := n
+				if config.reverse {
+					i = len(targets) - 1 - n
+				}
+				if spans[i].First == nil {
+					continue
+				}
+				g.progress.enter(n)
+				if config.workers > 1 {
+					err = g.writeParallel(i, config.workers)
+				} else {
+					err = g.writeRange(spans[i], i)
+				}
+				if err != nil {
+					break
+				}
+			}
+		}
+
+		// Close out the format and the file so write errors surface before
+		// the summary, naming it once its count is known
+		if err == nil || stopped(err) {
+			if countNamed {
+				out.path = strings.ReplaceAll(path, "{count}", strconv.Itoa(g.tally.written))
+				if err := checkOverwrite(config, out.path); err != nil {
+					return err
+				}
+			}
+			if endErr := g.end(); endErr != nil {
+				err = endErr
+			}
+		}
+
+		// The response only arrives once the whole body is sent
+		if p != nil {
+			err = p.finish(err)
+			posted = p.status
+		}
+	}
+	g.progress.clear()
+
+	// A finished job needs its checkpoint no more, while a stopped one
+	// saves exactly how far it got now that the output is flushed
+	saved := ""
+	if g.checkpoint != nil {
+		if err == nil {
+			if rmErr := os.Remove(config.checkpoint); rmErr != nil {
+				warn(logOut, config, "error removing checkpoint: %v", rmErr)
+			}
+		} else if stopped(err) {
+			if saveErr := g.saveCheckpoint(); saveErr != nil {
+				warn(logOut, config, "%v", saveErr)
+			} else {
+				saved = config.checkpoint
+			}
+		}
+	}
+
+	// A write error part way still gets a summary of how far the run got,
+	// ahead of the error itself
+	truncated := err == errLimitReached
+	timedOut := errors.Is(err, context.DeadlineExceeded) && genCtx.Err() != nil && ctx.Err() == nil
+	failed := err != nil && !stopped(err)
+	interrupted := err != nil && !truncated && !timedOut && !failed
+	if failed {
+		err = g.failure(err)
+	}
+
+	// Calculate execution time
+	duration := time.Since(startTime)
+
+	// Print summary
+	summary := &runSummary{
+		Event:        "summary",
+		Count:        g.tally.written,
+		Expected:     combined,
+		Reserved:     g.tally.reserved,
+		Excluded:     g.tally.excluded,
+		Duplicates:   g.tally.duplicates,
+		NonPublic:    g.tally.nonPublic,
+		Present:      g.tally.present,
+		Unmatched:    g.tally.unmatched,
+		Approximate:  config.approx,
+		Checkpoint:   saved,
+		Truncated:    truncated,
+		Interrupted:  interrupted,
+		TimedOut:     timedOut,
+		Failed:       failed,
+		ElapsedMs:    duration.Milliseconds(),
+		IPsPerSecond: float64(g.tally.written) / duration.Seconds(),
+		elapsed:      duration,
+	}
+	if config.approx {
+		summary.FPRate = config.fpRate
+	}
+	families := 0
+	for i, t := range targets {
+		summary.Targets = append(summary.Targets, targetSummary{Kind: t.kind(), CIDR: t.String(), Count: g.perTarget[i]})
+		if t.span.IsIPv4() {
+			summary.IPv4Count += g.perTarget[i]
+			families |= 1
+		} else {
+			summary.IPv6Count += g.perTarget[i]
+			families |= 2
+		}
+	}
+	summary.mixed = families == 3
+	if config.step > 1 {
+		summary.Step = config.step
+	}
+	if windowed && first <= last {
+		summary.Window = fmt.Sprintf("%d-%d", first, last)
+	}
+	if sharded {
+		summary.Shard = fmt.Sprintf("%d/%d", config.shard, config.shards)
+	}
+	if sampling || config.shuffle || config.shuffleIPs || config.jitter > 0 {
+		summary.Seed = config.seed
+	}
+	if resumed != nil && resumed.LastIP != "" {
+		summary.Resumed = resumed.LastIP
+		summary.Prior = resumed.Count
+	}
+	if len(g.rotated) > 0 {
+		summary.OutputDir = config.outputDir
+		summary.FilesWritten = len(g.rotated)
+		summary.Files = g.rotated
+	} else if g.archive != nil {
+		summary.Archive = archivePath
+		summary.FilesWritten = g.files
+	} else if out != nil {
+		summary.OutputFile = out.path
+		if out.file != nil {
+			summary.WriteMode = "written fresh"
+			if out.appended {
+				summary.WriteMode = "appended to existing content"
+			} else if out.pipe {
+				summary.WriteMode = "streamed to named pipe"
+			}
+		} else if posted != "" {
+			summary.WriteMode = "posted, server responded " + posted
+		}
+		if failed && out.tmp != "" {
+			summary.WriteMode = "incomplete file removed"
+		}
+		summary.SHA256 = out.checksum
+	} else {
+		summary.OutputDir = config.outputDir
+		summary.FilesWritten = g.files
+	}
+
+	// The planned count already allows for the options that choose fewer
+	// addresses on purpose, such as -step, -limit, -sample, -shard and
+	// -last-octet, so any shortfall is down to filters like -usable and
+	// -exclude; -match can't be planned for and skips by design
+	if failed {
+		summary.Error = err.Error()
+	} else if uint64(summary.Count) < planned && match == nil && resumed == nil {
+		warn(logOut, config, "%d IPs generated but %d were planned; %d were skipped or not reached", summary.Count, planned, planned-uint64(summary.Count))
+	}
+	summary.write(logOut, config)
+
+	// Metrics and the report are written for failed runs too, so whatever
+	// watches them sees the failure
+	finished := time.Now()
+	if config.metrics != "" {
+		if metricsErr := writeMetrics(config.metrics, summary, finished); metricsErr != nil && err == nil {
+			return metricsErr
+		}
+	}
+	if config.report != "" {
+		if reportErr := writeReport(config.report, config, summary, finished); reportErr != nil && err == nil {
+			return reportErr
+		}
+	}
+
+	// Report the cancellation or failure once the partial summary is out
+	if interrupted || failed {
+		return err
+	}
+	return nil
+}
+
+// printCounts writes the number of addresses in each network and in total,
+// plus the usable host count when -usable is set
+func printCounts(w io.Writer, config *Config, targets []target) {
+	total := new(big.Int)
+	usable := new(big.Int)
+	for _, t := range targets {
+		size := t.span.Size()
+		hosts := new(big.Int).Set(size)
+		if hasBroadcast(t) {
+			hosts.Sub(hosts, big.NewInt(2))
+		}
+		total.Add(total, size)
+		usable.Add(usable, hosts)
+
+		if config.usable {
+			fmt.Fprintf(w, "%s: %s (%s IPs, %s usable)\n", t.kind(), t, size, hosts)
+		} else {
+			fmt.Fprintf(w, "%s: %s (%s IPs)\n", t.kind(), t, size)
+		}
+	}
+
+	fmt.Fprintf(w, "Total IPs: %s\n", total)
+	if config.usable {
+		fmt.Fprintf(w, "Usable IPs: %s\n", usable)
+	}
+}
+
+// checkOverwrite returns an error if path already exists and the run would
+// replace it without -overwrite or -append
+func checkOverwrite(config *Config, path string) error {
+	if config.overwrite || config.append {
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("output file %s already exists; pass -overwrite to replace it or -append to add to it", path)
+	}
+	return nil
+}
+
+// prepareOutputDir resolves the output directory from config, creating it
+// if needed (or failing under -no-mkdir) and checking it is writable
+func prepareOutputDir(config *Config) error {
+	// Set default output directory if not specified
+	if config.outputDir == "" {
+		currentDir, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get current directory: %v", err)
+		}
+		config.outputDir = currentDir
+	}
+
+	// With -no-mkdir a missing directory is more likely a typo than a
+	// request for a new one
+	if config.noMkdir {
+		info, err := os.Stat(config.outputDir)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("output directory %s does not exist (not creating it with -no-mkdir)", config.outputDir)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to check output directory: %v", err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("output path %s is not a directory", config.outputDir)
+		}
+	}
+
+	// Create output directory if it doesn't exist
+	if err := os.MkdirAll(config.outputDir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w: %v", iplist.ErrNotWritable, err)
+	}
+
+	// Check the directory is writable before doing any work, including
+	// one MkdirAll just created
+	return validatePath(config.outputDir)
+}
+
+// outputFilename returns the custom filename from config, or a default
+// one built from the CIDR ranges and, unless -no-timestamp is set, a
+// timestamp. A single host gets a short name of its own, e.g.
+// ip_192-168-1-5.txt, as its one line needs no more to tell it apart.
+func outputFilename(config *Config) string {
+	if host := singleHost(config); config.filename == "" && config.template == "" && host != "" {
+		config.filename = "ip_" + strings.NewReplacer(".", "-", ":", "-").Replace(host)
+	}
+
+	// Generate default filename if not provided
+	if config.filename == "" {
+		now := time.Now()
+		timestamp := now.Format("20060102_150405")
+		source := canonicalList(config.cidr)
+		if config.cidrFile != "" {
+			base := filepath.Base(config.cidrFile)
+			source = strings.Trim(source+","+strings.TrimSuffix(base, filepath.Ext(base)), ",")
+		}
+		if config.ipRange != "" {
+			source = strings.Trim(source+","+canonicalList(config.ipRange), ",")
+		}
+		sanitizedCIDR := strings.Replace(source, "/", "_", -1)
+		sanitizedCIDR = strings.Replace(sanitizedCIDR, ".", "-", -1)
+		sanitizedCIDR = strings.Replace(sanitizedCIDR, ",", "_", -1)
+		config.filename = fmt.Sprintf("ip_list_%s_%s", sanitizedCIDR, timestamp)
+		if config.noTime {
+			config.filename = "ip_list_" + sanitizedCIDR
+		}
+		if config.template != "" {
+			config.filename = expandTemplate(config.template, map[string]string{
+				"cidr": sanitizedCIDR,
+				"date": now.Format("20060102"),
+				"time": now.Format("150405"),
+			})
+		}
+	}
+
+	return sanitizeFilename(withExtension(config, config.filename))
+}
+
+// singleHost returns the address in canonical form when the only target is
+// one host, a /32 or /128 or a range that starts and ends on the same
+// address, or "" otherwise
+func singleHost(config *Config) string {
+	if config.cidrFile != "" || (config.cidr == "") == (config.ipRange == "") {
+		return ""
+	}
+	entry := strings.TrimSpace(config.cidr + config.ipRange)
+	if ip, ipnet, err := net.ParseCIDR(entry); err == nil {
+		if ones, bits := ipnet.Mask.Size(); ones == bits {
+			return ip.String()
+		}
+	} else if span, err := iplist.ParseRange(entry); err == nil && span.First.Equal(span.Last) {
+		return span.First.String()
+	}
+	return ""
+}
+
+// canonicalList rewrites each address in a comma-separated list of CIDRs or
+// start-end ranges in its canonical form, so 2001:DB8::/32 and
+// 2001:0db8:0000::/32 both become 2001:db8::/32 and the same ranges always
+// get the same default filename, even on case-insensitive file systems.
+// The prefix length and any host bits are kept as given; an entry that
+// doesn't parse is left alone for parsing to report.
+func canonicalList(list string) string {
+	if list == "" {
+		return ""
+	}
+	entries := strings.Split(list, ",")
+	for i, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if ip, ipnet, err := net.ParseCIDR(entry); err == nil {
+			ones, _ := ipnet.Mask.Size()
+			entries[i] = fmt.Sprintf("%s/%d", ip, ones)
+		} else if span, err := iplist.ParseRange(entry); err == nil {
+			entries[i] = span.String()
+		} else {
+			entries[i] = entry
+		}
+	}
+	return strings.Join(entries, ",")
+}
+
+// templatePlaceholders are the names -output-template may use in braces
+var templatePlaceholders = []string{"cidr", "date", "time", "count"}
+
+// checkTemplate returns an error if tmpl uses a placeholder other than
+// templatePlaceholders or leaves a brace unclosed
+func checkTemplate(tmpl string) error {
+	rest := tmpl
+	for {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			return nil
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return fmt.Errorf("unclosed placeholder in -output-template %q", tmpl)
+		}
+		name := rest[start+1 : start+end]
+		known := false
+		for _, p := range templatePlaceholders {
+			known = known || name == p
+		}
+		if !known {
+			return fmt.Errorf("unknown placeholder {%s} in -output-template %q; use {%s}", name, tmpl, strings.Join(templatePlaceholders, "}, {"))
+		}
+		rest = rest[start+end+1:]
+	}
+}
+
+// expandTemplate replaces each {name} in tmpl with values[name]. Names
+// without a value, such as {count} before the run ends, are left as is.
+func expandTemplate(tmpl string, values map[string]string) string {
+	for name, value := range values {
+		tmpl = strings.ReplaceAll(tmpl, "{"+name+"}", value)
+	}
+	return tmpl
+}
+
+// splitFilename returns the file name for one -split subnet, e.g.
+// 10.0.5.0_24.txt, prefixed with the custom filename if one was given
+func splitFilename(config *Config, subnet *net.IPNet) string {
+	name := strings.Replace(subnet.String(), "/", "_", -1)
+	if config.filename != "" {
+		base := strings.TrimSuffix(config.filename, ".gz")
+		base = strings.TrimSuffix(base, formatExtensions[config.format])
+		name = base + "_" + name
+	}
+	return sanitizeFilename(withExtension(config, name))
+}
+
+// filenameReplacer swaps characters that Windows doesn't allow in file
+// names, such as the colons in IPv6 addresses, for dashes
+var filenameReplacer = strings.NewReplacer(":", "-", "*", "-", "?", "-", "<", "-", ">", "-", "|", "-", `"`, "-")
+
+// reservedNames are the Windows device names that can't be used as a file
+// name, even with an extension added
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizeFilename makes name valid on Windows as well as Unix: illegal
+// characters become dashes and a device name such as CON.txt gets a
+// leading underscore. Directory separators are left alone.
+func sanitizeFilename(name string) string {
+	name = filenameReplacer.Replace(name)
+	dir, base := filepath.Split(name)
+	stem, _, _ := strings.Cut(base, ".")
+	if reservedNames[strings.ToUpper(stem)] {
+		base = "_" + base
+	}
+	return dir + base
+}
+
+// withExtension ensures name has the extension for the output format,
+// followed by .gz when compressing
+func withExtension(config *Config, name string) string {
+	ext := formatExtensions[config.format]
+	name = strings.TrimSuffix(name, ".gz")
+	if !strings.HasSuffix(name, ext) {
+		name += ext
+	}
+	if config.gzip {
+		name += ".gz"
+	}
+	return name
+}
+
+// formatter renders a stream of addresses in one output format. Formats are
+// written incrementally so memory stays flat regardless of range size.
+type formatter interface {
+	begin(w *bufio.Writer) error
+	writeIP(w *bufio.Writer, ip net.IP) error
+	end(w *bufio.Writer) error
+}
+
+// newFormatter returns the formatter for the validated format in config,
+// or the reverse DNS annotator when -resolve is set. Formats that name the
+// network each address came from look it up in targets.
+func newFormatter(config *Config, targets []target) formatter {
+	if config.resolve {
+		return &resolveFormatter{workers: config.resolveWorkers, timeout: config.resolveTimeout}
+	}
+
+	lines := records{sep: config.sep, trailing: !config.trimSep, prefix: config.linePrefix, suffix: config.lineSuffix}
+	switch config.format {
+	case "json":
+		return &jsonFormatter{mapped: config.mapped}
+	case "csv":
+		return &csvFormatter{header: !config.noHeader, mapped: config.mapped}
+	case "enriched":
+		return &enrichedFormatter{dbs: config.geo, header: !config.noHeader}
+	case "tsv":
+		prefix := ""
+		if config.hexPrefix {
+			prefix = "0x"
+		}
+		return &tsvFormatter{networks: newNetworks(targets), header: !config.noHeader, prefix: prefix}
+	case "jsonl":
+		return &jsonlFormatter{networks: newNetworks(targets)}
+	case "int":
+		return &intFormatter{records: lines}
+	case "binary":
+		return &binaryFormatter{}
+	case "base64":
+		return &base64Formatter{width: config.wrap}
+	case "range":
+		return &rangeFormatter{records: lines}
+	case "nmap":
+		return &rangeFormatter{records: lines, nmap: true}
+	case "cidr", "cisco", "juniper":
+		return &cidrFormatter{records: lines, vendor: config.format, name: config.listName, aggregate: config.aggregate}
+	case "hex":
+		prefix := ""
+		if config.hexPrefix {
+			prefix = "0x"
+		}
+		return &hexFormatter{records: lines, prefix: prefix}
+	default:
+		f := &txtFormatter{records: lines, mapped: config.mapped}
+		if config.maskFormat != "none" {
+			f.masks = newNetworks(targets)
+			f.wildcard = config.maskFormat == "wildcard"
+		}
+		return f
+	}
+}
+
+// records writes one record per address followed by a separator, or with
+// the separator only between records when trailing is unset. The line
+// formats share it so -sep, -line-prefix and -line-suffix apply to all of
+// them.
+type records struct {
+	sep      string // Written after (or between) records, "\n" by default
+	trailing bool   // Whether the last record is followed by sep too
+	prefix   string // Written before each record
+	suffix   string // Written after each record, before sep
+	count    int    // Records written so far
+}
+
+// write writes one record, wrapped in the prefix and suffix, and its
+// separator
+func (r *records) write(w *bufio.Writer, record string) error {
+	r.count++
+	record = r.prefix + record + r.suffix
+	if r.trailing {
+		_, err := w.WriteString(record + r.sep)
+		return err
+	}
+	if r.count > 1 {
+		record = r.sep + record
+	}
+	_, err := w.WriteString(record)
+	return err
+}
+
+// txtFormatter writes one address per line, optionally followed by the
+// netmask or wildcard mask of its network
+type txtFormatter struct {
+	records
+	masks    networks // Networks to take masks from, empty unless -mask-format is set
+	wildcard bool     // Write the inverse of the netmask, as Cisco ACLs use
+	mapped   bool     // Write IPv4 addresses in IPv4-mapped IPv6 form
+}
+
+func (f *txtFormatter) begin(w *bufio.Writer) error { return nil }
+
+func (f *txtFormatter) writeIP(w *bufio.Writer, ip net.IP) error {
+	if f.masks.blocks == nil {
+		return f.write(w, ipText(ip, f.mapped))
+	}
+
+	network := f.masks.network(ip)
+	mask := make(net.IP, len(network.Mask))
+	for i, b := range network.Mask {
+		if f.wildcard {
+			b = ^b
+		}
+		mask[i] = b
+	}
+	return f.write(w, ip.String()+" "+mask.String())
+}
+
+func (f *txtFormatter) end(w *bufio.Writer) error { return nil }
+
+// jsonFormatter writes a JSON array of address strings, one element per line
+type jsonFormatter struct {
+	count  int  // Elements written so far, used to place separators
+	mapped bool // Write IPv4 addresses in IPv4-mapped IPv6 form
+}
+
+func (f *jsonFormatter) begin(w *bufio.Writer) error {
+	_, err := w.WriteString("[")
+	return err
+}
+
+func (f *jsonFormatter) writeIP(w *bufio.Writer, ip net.IP) error {
+	sep := ",\n"
+	if f.count == 0 {
+		sep = "\n"
+	}
+	f.count++
+	_, err := w.WriteString(sep + `  "` + ipText(ip, f.mapped) + `"`)
+	return err
+}
+
+func (f *jsonFormatter) end(w *bufio.Writer) error {
+	if f.count == 0 {
+		_, err := w.WriteString("]\n")
+		return err
+	}
+	_, err := w.WriteString("\n]\n")
+	return err
+}
+
+// csvFormatter writes an index,ip header followed by one row per address,
+// with the index starting at 1
+type csvFormatter struct {
+	header bool // Whether to start with the index,ip row
+	index  int  // Index of the last row written
+	mapped bool // Write IPv4 addresses in IPv4-mapped IPv6 form
+}
+
+func (f *csvFormatter) begin(w *bufio.Writer) error {
+	if !f.header {
+		return nil
+	}
+	_, err := w.WriteString("index,ip\n")
+	return err
+}
+
+func (f *csvFormatter) writeIP(w *bufio.Writer, ip net.IP) error {
+	f.index++
+	_, err := w.WriteString(strconv.Itoa(f.index) + "," + ipText(ip, f.mapped) + "\n")
+	return err
+}
+
+func (f *csvFormatter) end(w *bufio.Writer) error { return nil }
+
+// ipText returns ip as text, spelling an IPv4 address as IPv4-mapped IPv6
+// (::ffff:192.168.1.1) when mapped is set. net.IP prints its own 16-byte
+// mapped form as a dotted quad, so the prefix is added here.
+func ipText(ip net.IP, mapped bool) string {
+	if mapped {
+		if ip4 := ip.To4(); ip4 != nil {
+			return "::ffff:" + ip4.String()
+		}
+	}
+	return ip.String()
+}
+
+// networks finds the CIDR network each address came from, for formats that
+// name it. Start-end ranges are covered by their fewest CIDR blocks.
+type networks struct {
+	blocks []*net.IPNet // Networks covering the targets, in target order
+	cur    int          // Block holding the last address, checked first
+}
+
+// newNetworks returns the networks covering targets
+func newNetworks(targets []target) networks {
+	var n networks
+	for _, t := range targets {
+		if t.ipnet != nil {
+			n.blocks = append(n.blocks, t.ipnet)
+		} else {
+			n.blocks = append(n.blocks, t.span.CIDRs()...)
+		}
+	}
+	return n
+}
+
+// block returns the network containing ip in CIDR notation
+func (n *networks) block(ip net.IP) string {
+	if network := n.network(ip); network != nil {
+		return network.String()
+	}
+	return ""
+}
+
+// network returns the network containing ip, or nil for an address outside
+// every target. Addresses arrive in order, so the block of the previous
+// address is tried before searching.
+func (n *networks) network(ip net.IP) *net.IPNet {
+	if n.cur < len(n.blocks) && n.blocks[n.cur].Contains(ip) {
+		return n.blocks[n.cur]
+	}
+	for i, block := range n.blocks {
+		if block.Contains(ip) {
+			n.cur = i
+			return block
+		}
+	}
+	return nil
+}
+
+// tsvFormatter writes tab-separated rows of each address with its integer
+// and hex forms and the CIDR network containing it, after an optional
+// header row
+type tsvFormatter struct {
+	networks
+	header bool   // Whether to start with a column header row
+	prefix string // Written before the hex digits, "0x" or empty
+}
+
+func (f *tsvFormatter) begin(w *bufio.Writer) error {
+	if !f.header {
+		return nil
+	}
+	_, err := w.WriteString("ip\tinteger\thex\tcidr\n")
+	return err
+}
+
+func (f *tsvFormatter) writeIP(w *bufio.Writer, ip net.IP) error {
+	_, err := w.WriteString(ip.String() + "\t" + ipInteger(ip) + "\t" + f.prefix + ipHex(ip) + "\t" + f.block(ip) + "\n")
+	return err
+}
+
+func (f *tsvFormatter) end(w *bufio.Writer) error { return nil }
+
+// jsonlFormatter writes one JSON object per line holding each address, its
+// integer value and the CIDR network containing it. IPv6 integers are
+// written in full as JSON numbers.
+type jsonlFormatter struct {
+	networks
+}
+
+func (f *jsonlFormatter) begin(w *bufio.Writer) error { return nil }
+
+func (f *jsonlFormatter) writeIP(w *bufio.Writer, ip net.IP) error {
+	_, err := w.WriteString(`{"ip":"` + ip.String() + `","int":` + ipInteger(ip) + `,"cidr":"` + f.block(ip) + `"}` + "\n")
+	return err
+}
+
+func (f *jsonlFormatter) end(w *bufio.Writer) error { return nil }
+
+// intFormatter writes each address as its unsigned decimal integer value,
+// one per line. IPv6 addresses become 128-bit decimal strings.
+type intFormatter struct {
+	records
+}
+
+func (f *intFormatter) begin(w *bufio.Writer) error { return nil }
+
+func (f *intFormatter) writeIP(w *bufio.Writer, ip net.IP) error {
+	return f.write(w, ipInteger(ip))
+}
+
+func (f *intFormatter) end(w *bufio.Writer) error { return nil }
+
+// cidrFormatter writes each address as a single-host CIDR network, one per
+// line: a /32 for IPv4 and a /128 for IPv6, as route tables expect. For
+// -format cisco and juniper each network becomes a prefix-list entry of
+// that vendor's configuration syntax. With -aggregate, runs of consecutive
+// addresses, ascending or descending, are collected as rangeFormatter does
+// and written as the fewest CIDRs covering them, so a whole /24 is one
+// entry rather than 256.
+type cidrFormatter struct {
+	records
+	vendor    string     // Format name: cidr, cisco or juniper
+	name      string     // Prefix-list name for cisco and juniper
+	aggregate bool       // Collapse runs into covering CIDRs
+	run       addressRun // Run being collected under -aggregate
+}
+
+func (f *cidrFormatter) begin(w *bufio.Writer) error { return nil }
+
+func (f *cidrFormatter) writeIP(w *bufio.Writer, ip net.IP) error {
+	if !f.aggregate {
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return f.entry(w, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+
+	if f.run.extend(ip) {
+		return nil
+	}
+	if err := f.flush(w); err != nil {
+		return err
+	}
+	f.run.start(ip)
+	return nil
+}
+
+func (f *cidrFormatter) end(w *bufio.Writer) error { return f.flush(w) }
+
+// flush writes the run collected under -aggregate, if there is one. The
+// networks of a descending run are written highest first, keeping the
+// output in the order it was generated.
+func (f *cidrFormatter) flush(w *bufio.Writer) error {
+	if f.run.first == nil {
+		return nil
+	}
+	networks := f.run.span().CIDRs()
+	if f.run.down {
+		slices.Reverse(networks)
+	}
+	for _, ipnet := range networks {
+		if err := f.entry(w, ipnet); err != nil {
+			return err
+		}
+	}
+	f.run.first = nil
+	return nil
+}
+
+// entry writes ipnet as one line in the vendor's syntax, e.g.
+// "ip prefix-list NAME permit 10.0.0.0/24" for Cisco IOS or
+// "set policy-options prefix-list NAME 10.0.0.0/24" for Junos
+func (f *cidrFormatter) entry(w *bufio.Writer, ipnet *net.IPNet) error {
+	switch f.vendor {
+	case "cisco":
+		if ipnet.IP.To4() == nil {
+			return f.write(w, "ipv6 prefix-list "+f.name+" permit "+ipnet.String())
+		}
+		return f.write(w, "ip prefix-list "+f.name+" permit "+ipnet.String())
+	case "juniper":
+		return f.write(w, "set policy-options prefix-list "+f.name+" "+ipnet.String())
+	default:
+		return f.write(w, ipnet.String())
+	}
+}
+
+// ipInteger returns the decimal integer form of ip, e.g. 3232235776 for
+// 192.168.1.0
+func ipInteger(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(ip4)), 10)
+	}
+	return new(big.Int).SetBytes(ip.To16()).String()
+}
+
+// hexFormatter writes each address as lowercase hex digits, 8 for IPv4 and
+// 32 for IPv6, one per line
+type hexFormatter struct {
+	records
+	prefix string // Written before the digits, "0x" or empty
+}
+
+func (f *hexFormatter) begin(w *bufio.Writer) error { return nil }
+
+func (f *hexFormatter) writeIP(w *bufio.Writer, ip net.IP) error {
+	return f.write(w, f.prefix+ipHex(ip))
+}
+
+func (f *hexFormatter) end(w *bufio.Writer) error { return nil }
+
+// ipHex returns the lowercase hex form of ip, e.g. c0a80100 for
+// 192.168.1.0
+func ipHex(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return hex.EncodeToString(ip4)
+	}
+	return hex.EncodeToString(ip.To16())
+}
+
+// binaryFormatter writes each address as raw network-order bytes with no
+// separators: 4 bytes for IPv4 and 16 for IPv6
+type binaryFormatter struct{}
+
+func (f *binaryFormatter) begin(w *bufio.Writer) error { return nil }
+
+func (f *binaryFormatter) writeIP(w *bufio.Writer, ip net.IP) error {
+	if ip4 := ip.To4(); ip4 != nil {
+		_, err := w.Write(ip4)
+		return err
+	}
+	_, err := w.Write(ip.To16())
+	return err
+}
+
+func (f *binaryFormatter) end(w *bufio.Writer) error { return nil }
+
+// base64Formatter writes the packed records of binaryFormatter encoded as
+// standard base64, so the compact form can be pasted into text configs.
+// The text is broken into lines of width characters, as MIME does at its
+// default of 76, and ends with a newline.
+type base64Formatter struct {
+	width int            // Characters per line, 0 for a single line
+	lines *lineWrapper   // Breaks the encoded text into lines
+	enc   io.WriteCloser // Encoder writing to lines, open between begin and end
+}
+
+func (f *base64Formatter) begin(w *bufio.Writer) error {
+	f.lines = &lineWrapper{w: w, width: f.width}
+	f.enc = base64.NewEncoder(base64.StdEncoding, f.lines)
+	return nil
+}
+
+func (f *base64Formatter) writeIP(w *bufio.Writer, ip net.IP) error {
+	if ip4 := ip.To4(); ip4 != nil {
+		_, err := f.enc.Write(ip4)
+		return err
+	}
+	_, err := f.enc.Write(ip.To16())
+	return err
+}
+
+// end flushes the final partial group with its padding and closes the last
+// line
+func (f *base64Formatter) end(w *bufio.Writer) error {
+	if err := f.enc.Close(); err != nil {
+		return err
+	}
+	if f.lines.col > 0 {
+		return w.WriteByte('\n')
+	}
+	return nil
+}
+
+// lineWrapper passes text through to w with a newline after every width
+// bytes, or unbroken when width is 0
+type lineWrapper struct {
+	w     *bufio.Writer
+	width int // Bytes per line
+	col   int // Bytes written on the current line
+}
+
+func (l *lineWrapper) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := p
+		if l.width > 0 {
+			if l.col == l.width {
+				if err := l.w.WriteByte('\n'); err != nil {
+					return n, err
+				}
+				l.col = 0
+			}
+			chunk = p[:min(len(p), l.width-l.col)]
+		}
+		c, err := l.w.Write(chunk)
+		n += c
+		l.col += c
+		if err != nil {
+			return n, err
+		}
+		p = p[c:]
+	}
+	return n, nil
+}
+
+// addressRun is a run of consecutive addresses being collected, counting
+// up or, under -reverse, down
+type addressRun struct {
+	first net.IP // Start of the run, nil before the first address
+	last  net.IP // Latest address in the run
+	down  bool   // Whether the run counts down
+}
+
+// extend adds ip to the run if it carries the run on, reporting whether it
+// did. A run of one address can carry on in either direction.
+func (r *addressRun) extend(ip net.IP) bool {
+	if r.first == nil || len(ip) != len(r.last) {
+		return false
+	}
+	single := r.first.Equal(r.last)
+	switch {
+	case (single || !r.down) && ip.Equal(iplist.NextIP(r.last)):
+		r.down = false
+	case (single || r.down) && ip.Equal(iplist.PrevIP(r.last)):
+		r.down = true
+	default:
+		return false
+	}
+	r.last = ip
+	return true
+}
+
+// start begins a new run at ip
+func (r *addressRun) start(ip net.IP) {
+	r.first, r.last, r.down = ip, ip, false
+}
+
+// span returns the addresses of the run from lowest to highest
+func (r *addressRun) span() iplist.Range {
+	if r.down {
+		return iplist.Range{First: r.last, Last: r.first}
+	}
+	return iplist.Range{First: r.first, Last: r.last}
+}
+
+// rangeFormatter collapses runs of consecutive addresses into start-end
+// lines, the form -range reads, so a gap left by -exclude or -step starts
+// a new line and an unbroken range is a single one. A descending run, as
+// written under -reverse, is still written low-high. For -format nmap each
+// IPv4 run is instead split into as few nmap octet-range targets as it
+// takes, e.g. 10.0.0-3.0-255 for a /22 or 192.168.1.1-254 for the hosts of
+// a /24; nmap has no such syntax for IPv6, so an IPv6 run is written as
+// its covering CIDR networks.
+type rangeFormatter struct {
+	records
+	nmap bool       // Write nmap target specifications instead of start-end
+	run  addressRun // Run being collected
+}
+
+func (f *rangeFormatter) begin(w *bufio.Writer) error { return nil }
+
+func (f *rangeFormatter) writeIP(w *bufio.Writer, ip net.IP) error {
+	if f.run.extend(ip) {
+		return nil
+	}
+	if err := f.flush(w); err != nil {
+		return err
+	}
+	f.run.start(ip)
+	return nil
+}
+
+func (f *rangeFormatter) end(w *bufio.Writer) error { return f.flush(w) }
+
+// flush writes the collected run, if there is one
+func (f *rangeFormatter) flush(w *bufio.Writer) error {
+	if f.run.first == nil {
+		return nil
+	}
+	span := f.run.span()
+	if !f.nmap {
+		return f.write(w, span.First.String()+"-"+span.Last.String())
+	}
+
+	// The targets of a descending run are written highest first, keeping
+	// the output in the order it was generated
+	var specs []string
+	if first, last := span.First.To4(), span.Last.To4(); first != nil && last != nil {
+		specs = nmapTargets(binary.BigEndian.Uint32(first), binary.BigEndian.Uint32(last))
+	} else {
+		for _, ipnet := range span.CIDRs() {
+			specs = append(specs, ipnet.String())
+		}
+	}
+	if f.run.down {
+		slices.Reverse(specs)
+	}
+	for _, spec := range specs {
+		if err := f.write(w, spec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nmapTargets returns nmap octet-range targets covering the IPv4 addresses
+// first through last. Each target fixes the leading octets, ranges over one
+// octet and takes every value of the octets after it, and the largest such
+// target that fits is taken at each step.
+func nmapTargets(first, last uint32) []string {
+	var specs []string
+	for cur := uint64(first); cur <= uint64(last); {
+		// Find how many trailing octets can be left wide open: cur must sit
+		// at the start of such a block and the whole block must fit
+		k := 3
+		for ; k > 0; k-- {
+			size := uint64(1) << (8 * k)
+			if cur%size == 0 && cur+size-1 <= uint64(last) {
+				break
+			}
+		}
+		size := uint64(1) << (8 * k)
+
+		// Then take as many neighbouring blocks as fit without the ranged
+		// octet carrying into the one before it
+		from := cur / size % 256
+		to := min(255, from+(uint64(last)+1-cur)/size-1)
+
+		octets := make([]string, 4)
+		for j := 0; j < 4; j++ {
+			shift := 8 * (3 - j)
+			switch {
+			case j < 3-k:
+				octets[j] = strconv.FormatUint(cur>>shift&0xff, 10)
+			case j == 3-k && from == to:
+				octets[j] = strconv.FormatUint(from, 10)
+			case j == 3-k:
+				octets[j] = fmt.Sprintf("%d-%d", from, to)
+			default:
+				octets[j] = "0-255"
+			}
+		}
+		specs = append(specs, strings.Join(octets, "."))
+		cur += (to - from + 1) * size
+	}
+	return specs
+}
+
+// parseTargets collects the CIDR networks and start-end ranges to
+// enumerate, in the order given, along with a note for each CIDR whose
+// host bits were set
+func parseTargets(config *Config) ([]target, []string, error) {
+	var targets []target
+	var loose []string
+	if config.cidr != "" {
+		networks, notes, err := parseCIDRList(config.cidr)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, ipnet := range networks {
+			targets = append(targets, target{ipnet: ipnet, span: iplist.NetworkRange(ipnet)})
+		}
+		loose = append(loose, notes...)
+	}
+
+	if config.cidrFile != "" {
+		networks, notes, err := readCIDRFile(config.cidrFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, ipnet := range networks {
+			targets = append(targets, target{ipnet: ipnet, span: iplist.NetworkRange(ipnet)})
+		}
+		loose = append(loose, notes...)
+	}
+
+	if config.ipRange != "" {
+		for _, entry := range strings.Split(config.ipRange, ",") {
+			span, err := iplist.ParseRange(strings.TrimSpace(entry))
+			if err != nil {
+				return nil, nil, err
+			}
+			targets = append(targets, target{span: span})
+		}
+	}
+
+	return targets, loose, nil
+}
+
+// readCIDRFile parses a file of CIDR ranges, one per line. Blank lines and
+// anything after a # are ignored. Every invalid line is reported with its
+// line number, not just the first. Lines with host bits set are noted
+// with their line number too.
+func readCIDRFile(path string) ([]*net.IPNet, []string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error opening CIDR file: %v", err)
+	}
+	defer file.Close()
+
+	var networks []*net.IPNet
+	var problems, loose []string
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		ip, ipnet, err := net.ParseCIDR(line)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("  line %d: %v", lineNum, err))
+			continue
+		}
+		if note := hostBitsNote(line, ip, ipnet); note != "" {
+			loose = append(loose, fmt.Sprintf("%s line %d: %s", path, lineNum, note))
+		}
+		networks = append(networks, ipnet)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("error reading CIDR file: %v", err)
+	}
+
+	if len(problems) > 0 {
+		return nil, nil, fmt.Errorf("%w in %s:\n%s", iplist.ErrInvalidCIDR, path, strings.Join(problems, "\n"))
+	}
+	return networks, loose, nil
+}
+
+// kind labels the target type for the summary
+func (t target) kind() string {
+	if t.ipnet != nil {
+		return "CIDR Range"
+	}
+	return "IP Range"
+}
+
+// String returns the target in the notation it was given in
+func (t target) String() string {
+	if t.ipnet != nil {
+		return t.ipnet.String()
+	}
+	return t.span.String()
+}
+
+// hasBroadcast reports whether the target is an IPv4 network of /30 or
+// larger and so has distinct network and broadcast addresses. A /31 is a
+// point-to-point pair (RFC 3021) and a /32 a single host.
+func hasBroadcast(t target) bool {
+	if t.ipnet == nil {
+		return false
+	}
+	ones, bits := t.ipnet.Mask.Size()
+	return bits == 32 && ones <= 30
+}
+
+// readExisting loads the addresses in an earlier output file, one per
+// line, as 16-byte keys, which take far less memory than their text. A
+// gzip-compressed file is read through gzip. Blank lines and # comments
+// such as -group headers are ignored, and a file that doesn't exist yet
+// holds no addresses.
+func readExisting(path string) (map[[16]byte]struct{}, error) {
+	existing := make(map[[16]byte]struct{})
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return existing, nil
+	}
+	err := readIPList(path, func(ip net.IP) error {
+		existing[[16]byte(ip.To16())] = struct{}{}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("-dedupe-against: %v", err)
+	}
+	return existing, nil
+}
+
+// hostBitsNote describes entry if its address has host bits set, which
+// net.ParseCIDR silently masks off, or returns "" for a network address
+func hostBitsNote(entry string, ip net.IP, ipnet *net.IPNet) string {
+	if ip.Equal(ipnet.IP) {
+		return ""
+	}
+	return fmt.Sprintf("%s has host bits set; the network enumerated is %s", entry, ipnet)
+}
+
+// parseCIDRList parses a comma-separated list of CIDR ranges, failing on
+// the first invalid entry. Entries with host bits set are noted.
+func parseCIDRList(list string) ([]*net.IPNet, []string, error) {
+	var networks []*net.IPNet
+	var loose []string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		ip, ipnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w %q: %v", iplist.ErrInvalidCIDR, entry, err)
+		}
+		if note := hostBitsNote(entry, ip, ipnet); note != "" {
+			loose = append(loose, note)
+		}
+		networks = append(networks, ipnet)
+	}
+	return networks, loose, nil
+}
+
+// containedIn reports whether ip falls inside any of the given networks
+func containedIn(ip net.IP, networks []*net.IPNet) bool {
+	for _, ipnet := range networks {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// coveringNetwork returns the first of the given networks that contains
+// all of span, or nil if none does. Networks are contiguous, so holding
+// both ends means holding everything in between.
+func coveringNetwork(span iplist.Range, networks []*net.IPNet) *net.IPNet {
+	for _, ipnet := range networks {
+		if ipnet.Contains(span.First) && ipnet.Contains(span.Last) {
+			return ipnet
+		}
+	}
+	return nil
+}
+
+// isTerminal reports whether f is a character device such as a terminal,
+// rather than a file, a pipe or the null device scripts often redirect from
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	null, err := os.Stat(os.DevNull)
+	return err != nil || !os.SameFile(info, null)
+}
+
+// confirm writes prompt to out and reports whether the answer read from in
+// is yes
+func confirm(in io.Reader, out io.Writer, prompt string) bool {
+	fmt.Fprint(out, prompt)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
 }
 
 // validatePath checks if a path is valid and accessible
@@ -154,14 +2402,14 @@ func validatePath(path string) error {
 		return fmt.Errorf("error accessing path: %v", err)
 	}
 
-	// Check if path is writable
-	tmpFile := filepath.Join(path, ".tmp")
-	f, err := os.Create(tmpFile)
+	// Check if path is writable, using a uniquely named probe so an
+	// existing file is never clobbered
+	f, err := os.CreateTemp(path, ".ip-list-write-test-*")
 	if err != nil {
-		return fmt.Errorf("path is not writable: %s", path)
+		return fmt.Errorf("path is %w: %s", iplist.ErrNotWritable, path)
 	}
 	f.Close()
-	os.Remove(tmpFile)
+	os.Remove(f.Name())
 
 	return nil
 }
@@ -0,0 +1,956 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// parseTestArgs parses args as the command line would
+func parseTestArgs(args ...string) (*Config, error) {
+	fs := flag.NewFlagSet("ip-list-generator", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	config, _, err := parseArgs(fs, args)
+	return config, err
+}
+
+// testConfig is parseTestArgs for arguments that are expected to parse
+func testConfig(tb testing.TB, args ...string) *Config {
+	tb.Helper()
+	config, err := parseTestArgs(args...)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return config
+}
+
+// run parses args as the command line would and runs the generator with
+// its standard streams captured, returning what went to each
+func run(t *testing.T, args ...string) (string, string, error) {
+	t.Helper()
+	config, err := parseTestArgs(args...)
+	if err != nil {
+		return "", "", err
+	}
+	var stdout, stderr bytes.Buffer
+	err = generateIPsTo(context.Background(), config, &stdout, &stderr)
+	return stdout.String(), stderr.String(), err
+}
+
+// mustRun is run for arguments that are expected to succeed
+func mustRun(t *testing.T, args ...string) string {
+	t.Helper()
+	stdout, stderr, err := run(t, args...)
+	if err != nil {
+		t.Fatalf("%s: %v\n%s", strings.Join(args, " "), err, stderr)
+	}
+	return stdout
+}
+
+// lines splits output into its lines, without the final newline
+func lines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// equalLines fails the test unless got has exactly the lines want
+func equalLines(t *testing.T, got string, want []string) {
+	t.Helper()
+	if g := lines(got); strings.Join(g, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got %d lines %q, want %d lines %q", len(g), g, len(want), want)
+	}
+}
+
+func TestEnumeration(t *testing.T) {
+	tests := []struct {
+		name  string
+		cidr  string
+		args  []string
+		count int
+		first string
+		last  string
+	}{
+		{"host", "10.0.0.5/32", nil, 1, "10.0.0.5", "10.0.0.5"},
+		{"host usable", "10.0.0.5/32", []string{"-usable"}, 1, "10.0.0.5", "10.0.0.5"},
+		{"point-to-point", "10.0.0.4/31", nil, 2, "10.0.0.4", "10.0.0.5"},
+		{"point-to-point usable", "10.0.0.4/31", []string{"-usable"}, 2, "10.0.0.4", "10.0.0.5"},
+		{"slash 30", "10.0.0.4/30", nil, 4, "10.0.0.4", "10.0.0.7"},
+		{"slash 30 usable", "10.0.0.4/30", []string{"-usable"}, 2, "10.0.0.5", "10.0.0.6"},
+		{"slash 24", "192.168.1.0/24", nil, 256, "192.168.1.0", "192.168.1.255"},
+		{"slash 24 usable", "192.168.1.0/24", []string{"-usable"}, 254, "192.168.1.1", "192.168.1.254"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lines(mustRun(t, append([]string{"-cidr", tt.cidr, "-stdout"}, tt.args...)...))
+			if len(got) != tt.count {
+				t.Fatalf("got %d IPs, want %d", len(got), tt.count)
+			}
+			if got[0] != tt.first || got[len(got)-1] != tt.last {
+				t.Errorf("got %s through %s, want %s through %s", got[0], got[len(got)-1], tt.first, tt.last)
+			}
+		})
+	}
+}
+
+func TestInvalidCIDR(t *testing.T) {
+	for _, cidr := range []string{"10.0.0.0/33", "10.0.0/24", "not-a-cidr", "2001:db8::/129"} {
+		stdout, _, err := run(t, "-cidr", cidr, "-stdout")
+		if err == nil {
+			t.Errorf("-cidr %s: expected an error", cidr)
+		}
+		if stdout != "" {
+			t.Errorf("-cidr %s: wrote %q", cidr, stdout)
+		}
+	}
+}
+
+func TestBinaryFormat(t *testing.T) {
+	got := mustRun(t, "-cidr", "10.0.0.254/31,10.0.1.0/32", "-format", "binary", "-stdout")
+	want := []byte{10, 0, 0, 254, 10, 0, 0, 255, 10, 0, 1, 0}
+	if !bytes.Equal([]byte(got), want) {
+		t.Errorf("got % x, want % x", got, want)
+	}
+
+	got = mustRun(t, "-cidr", "2001:db8::1/128", "-format", "binary", "-stdout")
+	if len(got) != 16 || got[15] != 1 {
+		t.Errorf("got % x, want one 16-byte record", got)
+	}
+
+	if _, _, err := run(t, "-cidr", "10.0.0.0/32,2001:db8::/128", "-format", "binary", "-stdout"); err == nil {
+		t.Error("expected an error mixing IPv4 and IPv6 records")
+	}
+}
+
+func TestBinaryFormatNoTargets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cidrs.txt")
+	if err := os.WriteFile(path, []byte("# nothing yet\n\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{"binary", "base64"} {
+		if got := mustRun(t, "-cidr-file", path, "-format", format, "-stdout"); got != "" {
+			t.Errorf("-format %s wrote %q for no targets", format, got)
+		}
+	}
+}
+
+func TestBase64Format(t *testing.T) {
+	for _, tc := range []struct {
+		cidr string
+		wrap int
+	}{
+		{"10.0.0.0/28", 20},
+		{"10.0.0.0/28", 76},
+		{"10.0.0.0/28", 0},
+		{"10.0.0.0/30", 3},
+		{"2001:db8::/125", 76},
+	} {
+		args := []string{"-cidr", tc.cidr, "-stdout"}
+		packed := mustRun(t, append(args, "-format", "binary")...)
+		got := mustRun(t, append(args, "-format", "base64", "-wrap", strconv.Itoa(tc.wrap))...)
+
+		// Every line but the last is exactly the wrap width, and the
+		// lines decode back to the packed records
+		encoded := lines(got)
+		for i, line := range encoded {
+			if tc.wrap > 0 && (len(line) > tc.wrap || i < len(encoded)-1 && len(line) != tc.wrap) {
+				t.Errorf("%s -wrap %d: line %d is %d characters", tc.cidr, tc.wrap, i+1, len(line))
+			}
+		}
+		if tc.wrap == 0 && len(encoded) != 1 {
+			t.Errorf("%s -wrap 0: got %d lines, want 1", tc.cidr, len(encoded))
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(encoded, ""))
+		if err != nil {
+			t.Fatalf("%s -wrap %d: %v", tc.cidr, tc.wrap, err)
+		}
+		if string(decoded) != packed {
+			t.Errorf("%s -wrap %d: decoded % x, want % x", tc.cidr, tc.wrap, decoded, packed)
+		}
+	}
+
+	if _, _, err := run(t, "-cidr", "10.0.0.0/28", "-format", "base64", "-wrap", "-1", "-stdout"); err == nil {
+		t.Error("expected an error for a negative -wrap")
+	}
+
+	// Any -wrap outside base64 is refused, the default width included
+	for _, wrap := range []string{"76", "20", "0"} {
+		if _, _, err := run(t, "-cidr", "10.0.0.0/28", "-wrap", wrap, "-stdout"); err == nil {
+			t.Errorf("expected an error for -wrap %s with -format txt", wrap)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"wrap": 76}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := run(t, "-cidr", "10.0.0.0/28", "-config", path, "-stdout"); err == nil {
+		t.Error("expected an error for wrap in the config file with -format txt")
+	}
+}
+
+func TestIPv6(t *testing.T) {
+	got := mustRun(t, "-cidr", "2001:DB8:0:0::fc/126", "-stdout")
+	equalLines(t, got, []string{"2001:db8::fc", "2001:db8::fd", "2001:db8::fe", "2001:db8::ff"})
+
+	// Carrying across a byte boundary, and the last address of the space
+	got = mustRun(t, "-cidr", "2001:db8::ff00/120", "-stdout")
+	if l := lines(got); len(l) != 256 || l[0] != "2001:db8::ff00" || l[255] != "2001:db8::ffff" {
+		t.Errorf("got %d IPs from %s", len(l), l[0])
+	}
+	got = mustRun(t, "-range", "2001:db8::ffff:fffe-2001:db8::1:0:1", "-stdout")
+	equalLines(t, got, []string{"2001:db8::ffff:fffe", "2001:db8::ffff:ffff", "2001:db8::1:0:0", "2001:db8::1:0:1"})
+	got = mustRun(t, "-cidr", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe/127", "-stdout")
+	equalLines(t, got, []string{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffe", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"})
+}
+
+func TestIPv6TooLarge(t *testing.T) {
+	for _, cidr := range []string{"2001:db8::/103", "2001:db8::/64", "::/0"} {
+		stdout, _, err := run(t, "-cidr", cidr, "-stdout", "-force")
+		if !errors.Is(err, iplist.ErrRangeTooLarge) {
+			t.Errorf("-cidr %s: got %v, want ErrRangeTooLarge", cidr, err)
+		}
+		if stdout != "" {
+			t.Errorf("-cidr %s: wrote output", cidr)
+		}
+	}
+}
+
+func TestUsable(t *testing.T) {
+	tests := []struct {
+		cidr    string
+		count   int
+		skipped string
+	}{
+		{"192.168.1.0/24", 254, "Network/Broadcast Skipped: 2"},
+		{"192.168.1.0/30", 2, "Network/Broadcast Skipped: 2"},
+		{"192.168.1.0/31", 2, "Network/Broadcast Skipped: 0"},
+		{"192.168.1.1/32", 1, "Network/Broadcast Skipped: 0"},
+		{"192.168.1.0/30,192.168.2.0/30", 4, "Network/Broadcast Skipped: 4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			stdout, stderr, err := run(t, "-cidr", tt.cidr, "-usable", "-stdout")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(lines(stdout)); got != tt.count {
+				t.Errorf("got %d IPs, want %d", got, tt.count)
+			}
+			if !strings.Contains(stderr, tt.skipped+"\n") {
+				t.Errorf("summary is missing %q:\n%s", tt.skipped, stderr)
+			}
+		})
+	}
+
+	// IPv6 has no broadcast address and start-end ranges have no network
+	// address, so neither loses its ends
+	if got := len(lines(mustRun(t, "-cidr", "2001:db8::/126", "-usable", "-stdout"))); got != 4 {
+		t.Errorf("IPv6: got %d IPs, want 4", got)
+	}
+	if got := len(lines(mustRun(t, "-range", "10.0.0.0-10.0.0.3", "-usable", "-stdout"))); got != 4 {
+		t.Errorf("range: got %d IPs, want 4", got)
+	}
+}
+
+func TestCSVFormat(t *testing.T) {
+	got := lines(mustRun(t, "-cidr", "10.0.0.0/29", "-format", "csv", "-stdout"))
+	if len(got) != 9 {
+		t.Fatalf("got %d rows, want a header and 8 rows", len(got))
+	}
+	if got[0] != "index,ip" || got[1] != "1,10.0.0.0" || got[8] != "8,10.0.0.7" {
+		t.Errorf("got header %q, first row %q, last row %q", got[0], got[1], got[8])
+	}
+
+	got = lines(mustRun(t, "-cidr", "10.0.0.0/31", "-format", "csv", "-no-header", "-stdout"))
+	equalLines(t, strings.Join(got, "\n"), []string{"1,10.0.0.0", "2,10.0.0.1"})
+}
+
+func TestCSVExtension(t *testing.T) {
+	dir := t.TempDir()
+	mustRun(t, "-cidr", "10.0.0.0/29", "-format", "csv", "-output", dir, "-filename", "list")
+	mustRun(t, "-cidr", "10.0.0.0/29", "-format", "csv", "-output", dir, "-filename", "named.csv")
+	for _, name := range []string{"list.csv", "named.csv"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(data), "index,ip\n1,10.0.0.0\n") {
+			t.Errorf("%s starts %q", name, data)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "named.csv.csv")); err == nil {
+		t.Error("an explicit .csv extension was doubled")
+	}
+}
+
+func TestCount(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "out")
+	got := mustRun(t, "-cidr", "10.0.0.0/24", "-count", "-usable", "-output", dir)
+	for _, want := range []string{"Total IPs: 256\n", "Usable IPs: 254\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("count output is missing %q:\n%s", want, got)
+		}
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("count mode created %s", dir)
+	}
+
+	// Counting is arithmetic, so even ranges too large to write are fine
+	got = mustRun(t, "-cidr", "0.0.0.0/0,2001:db8::/64", "-count")
+	if !strings.Contains(got, "Total IPs: 18446744078004518912\n") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestSizeGuard(t *testing.T) {
+	// Exactly the threshold is allowed
+	got := mustRun(t, "-cidr", "10.0.0.0/12", "-stdout", "-yes", "-quiet")
+	if n := strings.Count(got, "\n"); n != maxUnforcedIPs {
+		t.Errorf("got %d IPs, want %d", n, maxUnforcedIPs)
+	}
+
+	// One more is refused with the count and how to override it
+	_, _, err := run(t, "-range", "10.0.0.0-10.16.0.0", "-stdout", "-yes")
+	if err == nil || !strings.Contains(err.Error(), "1048577") || !strings.Contains(err.Error(), "-force") {
+		t.Errorf("got %v, want a refusal naming the count and -force", err)
+	}
+	if _, _, err := run(t, "-cidr", "0.0.0.0/0", "-stdout"); err == nil {
+		t.Error("expected 0.0.0.0/0 to be refused")
+	}
+
+	// -force lifts the guard
+	got = mustRun(t, "-range", "10.0.0.0-10.16.0.0", "-stdout", "-yes", "-quiet", "-force")
+	if n := strings.Count(got, "\n"); n != maxUnforcedIPs+1 {
+		t.Errorf("got %d IPs with -force, want %d", n, maxUnforcedIPs+1)
+	}
+}
+
+func TestHexFormat(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-cidr", "192.168.1.0/30"}, []string{"c0a80100", "c0a80101", "c0a80102", "c0a80103"}},
+		{[]string{"-cidr", "10.255.255.254/31", "-hex-prefix"}, []string{"0x0afffffe", "0x0affffff"}},
+		{[]string{"-cidr", "2001:db8::fe/127"}, []string{"20010db80000000000000000000000fe", "20010db80000000000000000000000ff"}},
+	}
+	for _, tt := range tests {
+		got := mustRun(t, append(tt.args, "-format", "hex", "-stdout")...)
+		equalLines(t, got, tt.want)
+	}
+}
+
+func TestValidatePath(t *testing.T) {
+	dir := t.TempDir()
+	if err := validatePath(dir); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("the write probe was left behind: %v", entries)
+	}
+	if err := validatePath(filepath.Join(dir, "missing")); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("missing directory: got %v", err)
+	}
+}
+
+func TestReadOnlyOutputDir(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to a read-only directory")
+	}
+	dir := filepath.Join(t.TempDir(), "ro")
+	if err := os.Mkdir(dir, 0555); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := run(t, "-cidr", "10.0.0.0/30", "-output", dir)
+	if !errors.Is(err, iplist.ErrNotWritable) || !strings.Contains(err.Error(), "path is not writable: "+dir) {
+		t.Errorf("got %v, want the path is not writable error", err)
+	}
+
+	// A directory created for the run is checked the same way
+	_, _, err = run(t, "-cidr", "10.0.0.0/30", "-output", filepath.Join(dir, "sub"))
+	if !errors.Is(err, iplist.ErrNotWritable) {
+		t.Errorf("subdirectory: got %v, want ErrNotWritable", err)
+	}
+}
+
+func TestTSVFormat(t *testing.T) {
+	got := mustRun(t, "-cidr", "192.168.1.0/30", "-format", "tsv", "-stdout")
+	l := lines(got)
+	if len(l) != 5 {
+		t.Fatalf("got %d lines, want a header and 4 rows", len(l))
+	}
+	want := []string{
+		"ip\tinteger\thex\tcidr",
+		"192.168.1.0\t3232235776\tc0a80100\t192.168.1.0/30",
+		"192.168.1.3\t3232235779\tc0a80103\t192.168.1.0/30",
+	}
+	if l[0] != want[0] || l[1] != want[1] || l[4] != want[2] {
+		t.Errorf("got %q", l)
+	}
+
+	got = mustRun(t, "-range", "10.0.0.1-10.0.0.2", "-cidr", "2001:db8::1/128", "-format", "tsv", "-no-header", "-hex-prefix", "-stdout")
+	equalLines(t, got, []string{
+		"2001:db8::1\t42540766411282592856903984951653826561\t0x20010db8000000000000000000000001\t2001:db8::1/128",
+		"10.0.0.1\t167772161\t0x0a000001\t10.0.0.1/32",
+		"10.0.0.2\t167772162\t0x0a000002\t10.0.0.2/32",
+	})
+}
+
+// windowsSafe reports whether name is a valid Windows file name
+func windowsSafe(name string) bool {
+	if strings.ContainsAny(name, `<>:"/\|?*`) {
+		return false
+	}
+	stem, _, _ := strings.Cut(name, ".")
+	return !reservedNames[strings.ToUpper(stem)]
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"ip_list_2001-db8--_120.txt", "ip_list_2001-db8--_120.txt"},
+		{"a:b*c?d<e>f|g\"h.txt", "a-b-c-d-e-f-g-h.txt"},
+		{"CON.txt", "_CON.txt"},
+		{"con.txt.gz", "_con.txt.gz"},
+		{"lpt1", "_lpt1"},
+		{"CONSOLE.txt", "CONSOLE.txt"},
+		{"out/NUL.txt", "out/_NUL.txt"},
+	}
+	for _, tt := range tests {
+		if got := sanitizeFilename(tt.in); got != tt.want {
+			t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestIPv6Filename(t *testing.T) {
+	for _, args := range [][]string{
+		{"-cidr", "2001:db8::/120", "-no-timestamp"},
+		{"-cidr", "2001:DB8:0::/120,fe80::/120"},
+		{"-range", "2001:db8::1-2001:db8::ff", "-format", "csv"},
+		{"-cidr", "2001:db8::5/128"},
+		{"-cidr", "fe80::/120", "-filename", "CON"},
+	} {
+		name := outputFilename(testConfig(t, args...))
+		if !windowsSafe(name) {
+			t.Errorf("%v: %q is not a valid Windows file name", args, name)
+		}
+	}
+	if name := outputFilename(testConfig(t, "-cidr", "2001:db8::/120", "-no-timestamp")); name != "ip_list_2001-db8--_120.txt" {
+		t.Errorf("got %q", name)
+	}
+}
+
+func TestJSONLFormat(t *testing.T) {
+	got := lines(mustRun(t, "-cidr", "192.168.1.0/28,2001:db8::/126", "-format", "jsonl", "-stdout"))
+	if len(got) != 20 {
+		t.Fatalf("got %d lines, want 20", len(got))
+	}
+	for i, line := range got {
+		var record struct {
+			IP   string      `json:"ip"`
+			Int  json.Number `json:"int"`
+			CIDR string      `json:"cidr"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatalf("line %d is not valid JSON: %v: %s", i+1, err, line)
+		}
+		if record.IP == "" || record.Int == "" || record.CIDR == "" {
+			t.Errorf("line %d is missing a field: %s", i+1, line)
+		}
+	}
+	if got[1] != `{"ip":"192.168.1.1","int":3232235777,"cidr":"192.168.1.0/28"}` {
+		t.Errorf("got %s", got[1])
+	}
+	if got[19] != `{"ip":"2001:db8::3","int":42540766411282592856903984951653826563,"cidr":"2001:db8::/126"}` {
+		t.Errorf("got %s", got[19])
+	}
+}
+
+func TestReverse(t *testing.T) {
+	for _, args := range [][]string{
+		{"-cidr", "10.0.0.0/24"},
+		{"-cidr", "10.0.0.0/24", "-usable"},
+		{"-cidr", "10.0.0.0/30,2001:db8::ff00/120"},
+		{"-range", "10.0.0.250-10.0.1.5"},
+		{"-cidr", "0.0.0.0/30"},
+		{"-cidr", "255.255.255.252/30"},
+	} {
+		ascending := lines(mustRun(t, append(args, "-stdout")...))
+		descending := lines(mustRun(t, append(args, "-stdout", "-reverse")...))
+		slices.Reverse(ascending)
+		if !slices.Equal(ascending, descending) {
+			t.Errorf("%v: -reverse isn't the ascending output reversed:\n%q\n%q", args, descending, ascending)
+		}
+	}
+
+	// The network address comes last
+	got := lines(mustRun(t, "-cidr", "10.0.0.0/29", "-reverse", "-stdout"))
+	if got[0] != "10.0.0.7" || got[len(got)-1] != "10.0.0.0" {
+		t.Errorf("got %s through %s", got[0], got[len(got)-1])
+	}
+}
+
+func TestMixedFamilies(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cidrs.txt")
+	if err := os.WriteFile(path, []byte("10.0.0.254/31\n2001:db8::ff/128\n2001:db8::1:fffe/127\n192.168.0.255/32\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, stderr, err := run(t, "-cidr-file", path, "-stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	equalLines(t, stdout, []string{"10.0.0.254", "10.0.0.255", "2001:db8::ff", "2001:db8::1:fffe", "2001:db8::1:ffff", "192.168.0.255"})
+	for _, want := range []string{"  IPv4: 3\n", "  IPv6: 3\n"} {
+		if !strings.Contains(stderr, want) {
+			t.Errorf("summary is missing %q:\n%s", want, stderr)
+		}
+	}
+
+	// Each family keeps its own width in the numeric formats
+	got := mustRun(t, "-cidr-file", path, "-format", "hex", "-stdout")
+	equalLines(t, got, []string{"0a0000fe", "0a0000ff", "20010db80000000000000000000000ff", "20010db800000000000000000001fffe", "20010db800000000000000000001ffff", "c0a800ff"})
+}
+
+func TestShortfallWarning(t *testing.T) {
+	const shortfall = "were skipped or not reached"
+
+	// Options that choose fewer addresses on purpose are allowed for
+	for _, args := range [][]string{
+		{"-step", "2"},
+		{"-limit", "3"},
+		{"-limit", "100"},
+		{"-sample", "3", "-seed", "1"},
+		{"-shard", "1", "-shards", "2"},
+		{"-last-octet", "1"},
+		{"-first", "2"},
+		{"-boundaries"},
+	} {
+		_, stderr, err := run(t, append([]string{"-cidr", "10.0.0.0/29", "-stdout"}, args...)...)
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if strings.Contains(stderr, shortfall) {
+			t.Errorf("%v: unexpected warning:\n%s", args, stderr)
+		}
+	}
+
+	// Filters that drop planned addresses still warn
+	for _, args := range [][]string{
+		{"-usable"},
+		{"-exclude", "10.0.0.2/31"},
+		{"-step", "2", "-usable"},
+	} {
+		_, stderr, err := run(t, append([]string{"-cidr", "10.0.0.0/29", "-stdout"}, args...)...)
+		if err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if !strings.Contains(stderr, shortfall) {
+			t.Errorf("%v: no shortfall warning:\n%s", args, stderr)
+		}
+	}
+}
+
+func TestLineAffixes(t *testing.T) {
+	got := mustRun(t, "-cidr", "10.0.0.0/30", "-stdout", "-line-prefix", "allow ", "-line-suffix", ";")
+	equalLines(t, got, []string{"allow 10.0.0.0;", "allow 10.0.0.1;", "allow 10.0.0.2;", "allow 10.0.0.3;"})
+
+	// The affixes wrap each address inside the separator
+	got = mustRun(t, "-cidr", "10.0.0.0/31", "-stdout", "-line-suffix", "/32", "-sep", `, `)
+	if want := "10.0.0.0/32, 10.0.0.1/32, "; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got = mustRun(t, "-cidr", "10.0.0.0/31", "-stdout", "-line-prefix", "x", "-sep", `\t`)
+	if want := "x10.0.0.0\tx10.0.0.1\t"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Structured formats have no line to wrap
+	if _, _, err := run(t, "-cidr", "10.0.0.0/30", "-stdout", "-line-prefix", "a", "-format", "csv"); err == nil {
+		t.Error("expected an error for -line-prefix with -format csv")
+	}
+}
+
+func TestRangeFormat(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-cidr", "10.0.0.0/29"}, []string{"10.0.0.0-10.0.0.7"}},
+		{[]string{"-cidr", "10.0.0.0/30,10.0.0.4/30"}, []string{"10.0.0.0-10.0.0.7"}},
+		{[]string{"-cidr", "10.0.0.5/32"}, []string{"10.0.0.5-10.0.0.5"}},
+		{[]string{"-cidr", "10.0.0.0/29", "-exclude", "10.0.0.3/32"}, []string{"10.0.0.0-10.0.0.2", "10.0.0.4-10.0.0.7"}},
+		{[]string{"-cidr", "10.0.0.0/29", "-step", "4"}, []string{"10.0.0.0-10.0.0.0", "10.0.0.4-10.0.0.4"}},
+		{[]string{"-cidr", "10.0.0.0/31,2001:db8::/127"}, []string{"10.0.0.0-10.0.0.1", "2001:db8::-2001:db8::1"}},
+
+		// Descending runs are still written low-high
+		{[]string{"-cidr", "10.0.0.0/29", "-reverse"}, []string{"10.0.0.0-10.0.0.7"}},
+		{[]string{"-cidr", "10.0.0.0/29", "-exclude", "10.0.0.3/32", "-reverse"}, []string{"10.0.0.4-10.0.0.7", "10.0.0.0-10.0.0.2"}},
+		{[]string{"-cidr", "10.0.0.0/31,2001:db8::/127", "-reverse"}, []string{"2001:db8::-2001:db8::1", "10.0.0.0-10.0.0.1"}},
+	} {
+		got := mustRun(t, append(tc.args, "-format", "range", "-stdout")...)
+		if !slices.Equal(lines(got), tc.want) {
+			t.Errorf("%v: got %q, want %q", tc.args, lines(got), tc.want)
+		}
+	}
+}
+
+func TestMaskFormat(t *testing.T) {
+	got := lines(mustRun(t, "-cidr", "10.0.0.0/26", "-mask-format", "netmask", "-stdout"))
+	if len(got) != 64 || got[0] != "10.0.0.0 255.255.255.192" || got[63] != "10.0.0.63 255.255.255.192" {
+		t.Errorf("netmask: got %d lines, %q through %q", len(got), got[0], got[len(got)-1])
+	}
+	got = lines(mustRun(t, "-cidr", "10.0.0.0/26", "-mask-format", "wildcard", "-stdout"))
+	if len(got) != 64 || got[0] != "10.0.0.0 0.0.0.63" || got[63] != "10.0.0.63 0.0.0.63" {
+		t.Errorf("wildcard: got %d lines, %q through %q", len(got), got[0], got[len(got)-1])
+	}
+
+	// IPv6 masks are written in their own notation
+	got = lines(mustRun(t, "-cidr", "2001:db8::/126", "-mask-format", "netmask", "-stdout"))
+	if got[0] != "2001:db8:: ffff:ffff:ffff:ffff:ffff:ffff:ffff:fffc" {
+		t.Errorf("IPv6 netmask: got %q", got[0])
+	}
+
+	if _, err := parseTestArgs("-cidr", "10.0.0.0/26", "-mask-format", "bogus"); err == nil {
+		t.Error("expected an error for an unknown -mask-format")
+	}
+}
+
+func TestBoundaries(t *testing.T) {
+	for _, tc := range []struct {
+		cidr string
+		want []string
+	}{
+		{"10.0.0.0/24", []string{"10.0.0.0", "10.0.0.255"}},
+		{"10.0.0.0/30", []string{"10.0.0.0", "10.0.0.3"}},
+		{"10.0.0.0/31", []string{"10.0.0.0", "10.0.0.1"}},
+		{"10.0.0.5/32", []string{"10.0.0.5"}},
+		{"2001:db8::/126", []string{"2001:db8::", "2001:db8::3"}},
+	} {
+		got := mustRun(t, "-cidr", tc.cidr, "-boundaries", "-stdout")
+		if !slices.Equal(lines(got), tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.cidr, lines(got), tc.want)
+		}
+	}
+
+	// Each -split subnet gets its own pair
+	dir := t.TempDir()
+	if _, _, err := run(t, "-cidr", "10.0.0.0/24", "-split", "/26", "-boundaries", "-output", dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range [][]string{{"10.0.0.0", "10.0.0.63"}, {"10.0.0.64", "10.0.0.127"}, {"10.0.0.128", "10.0.0.191"}, {"10.0.0.192", "10.0.0.255"}} {
+		data, err := os.ReadFile(filepath.Join(dir, want[0]+"_26.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		equalLines(t, string(data), want)
+	}
+}
+
+func TestNmapFormat(t *testing.T) {
+	for _, tc := range []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-cidr", "10.0.0.0/24"}, []string{"10.0.0.0-255"}},
+		{[]string{"-cidr", "10.0.0.0/23"}, []string{"10.0.0-1.0-255"}},
+		{[]string{"-cidr", "10.0.0.0/22"}, []string{"10.0.0-3.0-255"}},
+		{[]string{"-cidr", "10.0.0.0/25"}, []string{"10.0.0.0-127"}},
+		{[]string{"-cidr", "10.0.0.5/32"}, []string{"10.0.0.5"}},
+		{[]string{"-cidr", "10.0.0.0/29", "-exclude", "10.0.0.3/32"}, []string{"10.0.0.0-2", "10.0.0.4-7"}},
+		{[]string{"-range", "10.0.0.250-10.0.2.5"}, []string{"10.0.0.250-255", "10.0.1.0-255", "10.0.2.0-5"}},
+		{[]string{"-cidr", "2001:db8::/127"}, []string{"2001:db8::/127"}},
+		{[]string{"-cidr", "10.0.0.0/23", "-reverse"}, []string{"10.0.0-1.0-255"}},
+		{[]string{"-range", "10.0.0.250-10.0.2.5", "-reverse"}, []string{"10.0.2.0-5", "10.0.1.0-255", "10.0.0.250-255"}},
+	} {
+		got := mustRun(t, append(tc.args, "-format", "nmap", "-stdout")...)
+		if !slices.Equal(lines(got), tc.want) {
+			t.Errorf("%v: got %q, want %q", tc.args, lines(got), tc.want)
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	dir := t.TempDir()
+	// With a file as output the summary goes to stdout
+	summary, _, err := run(t, "-cidr", "10.0.0.0/8", "-timeout", "20ms", "-force", "-yes", "-no-progress", "-output", dir, "-filename", "partial")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(summary, "Output Time-Limited: stopped after -timeout 20ms") {
+		t.Errorf("summary doesn't note the time limit:\n%s", summary)
+	}
+
+	// What was written is kept, in order and ending on a whole line
+	data, err := os.ReadFile(filepath.Join(dir, "partial.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := lines(string(data))
+	if len(got) == 0 || len(got) >= 1<<24 {
+		t.Fatalf("got %d IPs, want a partial run", len(got))
+	}
+	if data[len(data)-1] != '\n' || got[0] != "10.0.0.0" {
+		t.Errorf("partial output starts with %q and ends with %q", got[0], data[len(data)-1:])
+	}
+}
+
+func TestMapped(t *testing.T) {
+	want := []string{"::ffff:192.168.1.0", "::ffff:192.168.1.1", "::ffff:192.168.1.2", "::ffff:192.168.1.3"}
+	equalLines(t, mustRun(t, "-cidr", "192.168.1.0/30", "-mapped", "-stdout"), want)
+
+	var got []string
+	if err := json.Unmarshal([]byte(mustRun(t, "-cidr", "192.168.1.0/30", "-mapped", "-format", "json", "-stdout")), &got); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("json: got %q, want %q", got, want)
+	}
+	csv := lines(mustRun(t, "-cidr", "192.168.1.0/30", "-mapped", "-format", "csv", "-stdout"))
+	if len(csv) != 5 || csv[1] != "1,::ffff:192.168.1.0" || csv[4] != "4,::ffff:192.168.1.3" {
+		t.Errorf("csv: got %q", csv)
+	}
+
+	// IPv6 ranges and formats without a text address are refused
+	for _, args := range [][]string{
+		{"-cidr", "192.168.1.0/31,2001:db8::/127"},
+		{"-cidr", "192.168.1.0/30", "-format", "hex"},
+		{"-cidr", "192.168.1.0/30", "-format", "jsonl"},
+	} {
+		if _, _, err := run(t, append(args, "-mapped", "-stdout")...); err == nil {
+			t.Errorf("%v: expected an error with -mapped", args)
+		}
+	}
+}
+
+func TestShards(t *testing.T) {
+	for _, tc := range []struct {
+		args   []string
+		shards int
+	}{
+		{[]string{"-cidr", "10.0.0.0/24"}, 5},
+		{[]string{"-cidr", "10.0.0.0/30,2001:db8::/125", "-range", "10.1.0.250-10.1.1.3"}, 3},
+		{[]string{"-cidr", "10.0.0.0/29"}, 8},
+	} {
+		full := lines(mustRun(t, append(tc.args, "-stdout")...))
+
+		// In shard order the partitions join up to the full run, so none
+		// overlap or leave a gap, and they differ in size by at most one
+		var joined []string
+		smallest, largest := len(full), 0
+		for shard := 1; shard <= tc.shards; shard++ {
+			part := lines(mustRun(t, append(tc.args, "-stdout", "-shard", strconv.Itoa(shard), "-shards", strconv.Itoa(tc.shards))...))
+			joined = append(joined, part...)
+			smallest, largest = min(smallest, len(part)), max(largest, len(part))
+		}
+		if !slices.Equal(joined, full) {
+			t.Errorf("%v: %d shards don't tile the range:\n%q\n%q", tc.args, tc.shards, joined, full)
+		}
+		if largest-smallest > 1 {
+			t.Errorf("%v: shards range from %d to %d IPs", tc.args, smallest, largest)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"-shard", "0", "-shards", "5"},
+		{"-shard", "6", "-shards", "5"},
+		{"-shard", "1"},
+		{"-shards", "5"},
+	} {
+		if _, _, err := run(t, append([]string{"-cidr", "10.0.0.0/24", "-stdout"}, args...)...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestCIDRFormat(t *testing.T) {
+	got := mustRun(t, "-cidr", "10.0.0.0/30", "-format", "cidr", "-stdout")
+	equalLines(t, got, []string{"10.0.0.0/32", "10.0.0.1/32", "10.0.0.2/32", "10.0.0.3/32"})
+	got = mustRun(t, "-cidr", "2001:db8::/126", "-format", "cidr", "-stdout")
+	equalLines(t, got, []string{"2001:db8::/128", "2001:db8::1/128", "2001:db8::2/128", "2001:db8::3/128"})
+}
+
+func TestPrefixListFormats(t *testing.T) {
+	got := mustRun(t, "-cidr", "10.0.0.0/31,2001:db8::/127", "-format", "cisco", "-list-name", "EDGE", "-stdout")
+	equalLines(t, got, []string{
+		"ip prefix-list EDGE permit 10.0.0.0/32",
+		"ip prefix-list EDGE permit 10.0.0.1/32",
+		"ipv6 prefix-list EDGE permit 2001:db8::/128",
+		"ipv6 prefix-list EDGE permit 2001:db8::1/128",
+	})
+	got = mustRun(t, "-cidr", "10.0.0.0/31,2001:db8::/127", "-format", "juniper", "-stdout")
+	equalLines(t, got, []string{
+		"set policy-options prefix-list IP-LIST 10.0.0.0/32",
+		"set policy-options prefix-list IP-LIST 10.0.0.1/32",
+		"set policy-options prefix-list IP-LIST 2001:db8::/128",
+		"set policy-options prefix-list IP-LIST 2001:db8::1/128",
+	})
+}
+
+func TestAggregate(t *testing.T) {
+	// Each run of consecutive hosts becomes the networks that cover it
+	args := []string{"-cidr", "10.0.0.0/29,10.0.0.9/32", "-exclude", "10.0.0.6/32", "-aggregate", "-stdout"}
+	equalLines(t, mustRun(t, append(args, "-format", "cidr")...), []string{"10.0.0.0/30", "10.0.0.4/31", "10.0.0.7/32", "10.0.0.9/32"})
+	equalLines(t, mustRun(t, append(args, "-format", "cisco")...), []string{
+		"ip prefix-list IP-LIST permit 10.0.0.0/30",
+		"ip prefix-list IP-LIST permit 10.0.0.4/31",
+		"ip prefix-list IP-LIST permit 10.0.0.7/32",
+		"ip prefix-list IP-LIST permit 10.0.0.9/32",
+	})
+
+	// Under -reverse the runs count down but collapse the same way
+	equalLines(t, mustRun(t, "-cidr", "10.0.0.0/29", "-format", "cidr", "-aggregate", "-reverse", "-stdout"), []string{"10.0.0.0/29"})
+	equalLines(t, mustRun(t, append(args, "-format", "cidr", "-reverse")...), []string{"10.0.0.9/32", "10.0.0.7/32", "10.0.0.4/31", "10.0.0.0/30"})
+	equalLines(t, mustRun(t, "-cidr", "10.0.0.0/29", "-format", "cisco", "-aggregate", "-reverse", "-stdout"), []string{"ip prefix-list IP-LIST permit 10.0.0.0/29"})
+	equalLines(t, mustRun(t, "-cidr", "2001:db8::/126", "-aggregate", "-format", "juniper", "-stdout"), []string{
+		"set policy-options prefix-list IP-LIST 2001:db8::/126",
+	})
+
+	if _, _, err := run(t, "-cidr", "10.0.0.0/30", "-aggregate", "-stdout"); err == nil {
+		t.Error("expected an error for -aggregate with -format txt")
+	}
+}
+
+func TestIPv6Spellings(t *testing.T) {
+	spellings := []string{"2001:db8::/120", "2001:DB8::/120", "2001:0db8:0000::/120", "2001:0DB8:0:0:0:0:0:0/120"}
+	wantName := outputFilename(testConfig(t, "-cidr", spellings[0], "-no-timestamp"))
+	wantIPs := mustRun(t, "-cidr", spellings[0], "-stdout")
+	for _, cidr := range spellings[1:] {
+		if name := outputFilename(testConfig(t, "-cidr", cidr, "-no-timestamp")); name != wantName {
+			t.Errorf("%s: filename %q, want %q", cidr, name, wantName)
+		}
+		if got := mustRun(t, "-cidr", cidr, "-stdout"); got != wantIPs {
+			t.Errorf("%s: output differs from %s", cidr, spellings[0])
+		}
+	}
+
+	// Ranges are rewritten too, and entries that don't parse are left for
+	// parsing to report
+	for in, want := range map[string]string{
+		"2001:DB8::/32,10.0.0.0/24":  "2001:db8::/32,10.0.0.0/24",
+		"2001:0db8::1-2001:0DB8::FF": "2001:db8::1-2001:db8::ff",
+		" 2001:DB8::5/128 ":          "2001:db8::5/128",
+		"2001:DB8::/129":             "2001:DB8::/129",
+		"not-a-cidr":                 "not-a-cidr",
+	} {
+		if got := canonicalList(in); got != want {
+			t.Errorf("canonicalList(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSingleHostFilename(t *testing.T) {
+	for want, args := range map[string][]string{
+		"ip_192-168-1-5.txt": {"-cidr", "192.168.1.5/32"},
+		"ip_2001-db8--5.txt": {"-cidr", "2001:DB8::5/128"},
+		"ip_10-0-0-9.csv":    {"-range", "10.0.0.9-10.0.0.9", "-format", "csv"},
+		"ip_10-0-0-9.txt.gz": {"-cidr", "10.0.0.9/32", "-gzip"},
+		"host.txt":           {"-cidr", "192.168.1.5/32", "-filename", "host"},
+	} {
+		if name := outputFilename(testConfig(t, args...)); name != want {
+			t.Errorf("%v: got %q, want %q", args, name, want)
+		}
+	}
+
+	// Anything more than one host keeps the range in the name
+	for _, args := range [][]string{
+		{"-cidr", "192.168.1.4/31"},
+		{"-cidr", "192.168.1.5/32,192.168.1.9/32"},
+		{"-range", "10.0.0.9-10.0.0.10"},
+		{"-cidr", "192.168.1.5/32", "-range", "10.0.0.9-10.0.0.9"},
+	} {
+		if name := outputFilename(testConfig(t, args...)); strings.HasPrefix(name, "ip_") && !strings.HasPrefix(name, "ip_list_") {
+			t.Errorf("%v: got the single-host name %q", args, name)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	stdout, stderr, err := run(t, "-cidr", "10.0.0.0/24", "-match", `\.1$`, "-stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	equalLines(t, stdout, []string{"10.0.0.1"})
+	if !strings.Contains(stderr, "Unmatched IPs Skipped: 255\n") {
+		t.Errorf("summary doesn't count the unmatched IPs:\n%s", stderr)
+	}
+
+	got := mustRun(t, "-cidr", "10.0.0.0/24", "-match", `\.2[0-4][0-9]$`, "-stdout")
+	if l := lines(got); len(l) != 50 || l[0] != "10.0.0.200" || l[49] != "10.0.0.249" {
+		t.Errorf("got %d IPs, %q through %q", len(l), l[0], l[len(l)-1])
+	}
+
+	// The pattern applies to the text form, so IPv6 matches as written
+	got = mustRun(t, "-cidr", "2001:db8::/120", "-match", "::a", "-stdout")
+	equalLines(t, got, []string{"2001:db8::a", "2001:db8::a0", "2001:db8::a1", "2001:db8::a2", "2001:db8::a3", "2001:db8::a4", "2001:db8::a5", "2001:db8::a6", "2001:db8::a7", "2001:db8::a8", "2001:db8::a9", "2001:db8::aa", "2001:db8::ab", "2001:db8::ac", "2001:db8::ad", "2001:db8::ae", "2001:db8::af"})
+
+	if _, _, err := run(t, "-cidr", "10.0.0.0/24", "-match", "(", "-stdout"); err == nil {
+		t.Error("expected an error for an invalid -match pattern")
+	}
+}
+
+func TestSeed(t *testing.T) {
+	for _, args := range [][]string{
+		{"-cidr", "10.0.0.0/22", "-sample", "20"},
+		{"-cidr", "10.0.0.0/24,2001:db8::/120", "-shuffle"},
+		{"-cidr", "10.0.0.0/22", "-shuffle-hosts"},
+		{"-cidr", "10.0.0.0/22", "-sample", "50", "-shuffle"},
+	} {
+		args = append(args, "-stdout")
+		first := mustRun(t, append(args, "-seed", "42")...)
+		if again := mustRun(t, append(args, "-seed", "42")...); again != first {
+			t.Errorf("%v: -seed 42 gave different output on a second run", args)
+		}
+		if other := mustRun(t, append(args, "-seed", "43")...); other == first {
+			t.Errorf("%v: -seed 43 gave the same output as -seed 42", args)
+		}
+
+		// Without -seed the summary reports the one picked, which
+		// reproduces the run
+		stdout, stderr, err := run(t, args...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, rest, ok := strings.Cut(stderr, "Random Seed: ")
+		if !ok {
+			t.Fatalf("%v: summary doesn't report the seed:\n%s", args, stderr)
+		}
+		seed, _, _ := strings.Cut(rest, " ")
+		if again := mustRun(t, append(args, "-seed", seed)...); again != stdout {
+			t.Errorf("%v: the reported seed %s doesn't reproduce the run", args, seed)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	stdout, stderr, err := run(t, "-cidr", "10.0.0.8/30,10.0.0.0/29,10.0.0.4/31", "-sort", "-stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want []string
+	for i := 0; i <= 11; i++ {
+		want = append(want, "10.0.0."+strconv.Itoa(i))
+	}
+	equalLines(t, stdout, want)
+	for _, line := range []string{"IP Range: 10.0.0.0-10.0.0.11 (12 IPs)\n", "Total IPs Generated: 12\n", "Expected Total: 12\n"} {
+		if !strings.Contains(stderr, line) {
+			t.Errorf("summary is missing %q:\n%s", line, stderr)
+		}
+	}
+
+	// Ranges that don't touch stay separate, in ascending order
+	got := mustRun(t, "-cidr", "10.0.1.0/31,2001:db8::/127", "-range", "10.0.0.5-10.0.0.6", "-sort", "-stdout")
+	equalLines(t, got, []string{"10.0.0.5", "10.0.0.6", "10.0.1.0", "10.0.1.1", "2001:db8::", "2001:db8::1"})
+}
@@ -0,0 +1,26 @@
+package iplist
+
+import "errors"
+
+// Errors returned by the package, wrapped with the details of each failure.
+// Match them with errors.Is rather than by message.
+var (
+	// ErrInvalidCIDR means a CIDR string could not be parsed
+	ErrInvalidCIDR = errors.New("invalid CIDR format")
+
+	// ErrInvalidRange means a start-end range could not be parsed or is
+	// backwards
+	ErrInvalidRange = errors.New("invalid IP range")
+
+	// ErrRangeTooLarge means an IPv6 range holds more addresses than a
+	// /MinIPv6Prefix network and will not be enumerated
+	ErrRangeTooLarge = errors.New("too large")
+
+	// ErrWrite means writing the generated addresses to the caller's
+	// writer failed; the writer's own error is wrapped alongside it
+	ErrWrite = errors.New("write failed")
+
+	// ErrNotWritable means the output directory or file could not be
+	// created or written to; the underlying error is wrapped alongside it
+	ErrNotWritable = errors.New("not writable")
+)
@@ -0,0 +1,42 @@
+package iplist
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// failingWriter fails every write
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("disk on fire") }
+
+func TestErrors(t *testing.T) {
+	_, big, _ := net.ParseCIDR("2001:db8::/16")
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"GenerateIPs bad CIDR", func() error { _, err := GenerateIPs("10.0.0.0/33", io.Discard); return err }(), ErrInvalidCIDR},
+		{"NewReader bad CIDR", func() error { _, err := NewReader("nope"); return err }(), ErrInvalidCIDR},
+		{"Iterate bad CIDR", func() error { _, err := Iterate(context.Background(), "10.0.0/24"); return err }(), ErrInvalidCIDR},
+		{"no dash", func() error { _, err := ParseRange("10.0.0.1"); return err }(), ErrInvalidRange},
+		{"bad start", func() error { _, err := ParseRange("10.0.0-10.0.0.2"); return err }(), ErrInvalidRange},
+		{"mixed versions", func() error { _, err := ParseRange("10.0.0.1-::1"); return err }(), ErrInvalidRange},
+		{"backwards", func() error { _, err := ParseRange("10.0.0.9-10.0.0.1"); return err }(), ErrInvalidRange},
+		{"short IPv6 prefix", CheckSize(big), ErrRangeTooLarge},
+		{"IPv6 range too big", CheckRangeSize(NetworkRange(big)), ErrRangeTooLarge},
+		{"GenerateIPs write", func() error { _, err := GenerateIPs("10.0.0.0/24", failingWriter{}); return err }(), ErrWrite},
+		{"GenerateIPs IPv6 write", func() error { _, err := GenerateIPs("2001:db8::/120", failingWriter{}); return err }(), ErrWrite},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !errors.Is(tt.err, tt.want) {
+				t.Errorf("got %v, want an error matching %v", tt.err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,309 @@
+// Package iplist enumerates the IP addresses contained in CIDR ranges.
+package iplist
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+)
+
+// MinIPv6Prefix is the shortest IPv6 prefix that will be enumerated.
+// Anything larger than a /104 would produce 2^24+ addresses.
+const MinIPv6Prefix = 104
+
+// GenerateIPs writes every address in cidr to w, one per line, and returns
+// the number of addresses written
+func GenerateIPs(cidr string, w io.Writer) (int, error) {
+	// Validate and parse CIDR notation
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrInvalidCIDR, err)
+	}
+	if err := CheckSize(ipnet); err != nil {
+		return 0, err
+	}
+	if len(ipnet.Mask) == net.IPv4len {
+		return generateIPv4(ipnet, w)
+	}
+
+	// Create buffered writer for better performance
+	writer := bufio.NewWriter(w)
+
+	count := 0
+	err = Enumerate(ipnet, func(ip net.IP) error {
+		if _, err := writer.WriteString(ip.String() + "\n"); err != nil {
+			return fmt.Errorf("%w: %w", ErrWrite, err)
+		}
+		count++
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+
+	return count, flush(writer)
+}
+
+// generateIPv4 is GenerateIPs for an IPv4 network. It counts through the
+// addresses as integers and formats each into one reused buffer, so unlike
+// the net.IP path nothing is allocated per address.
+func generateIPv4(ipnet *net.IPNet, w io.Writer) (int, error) {
+	writer := bufio.NewWriter(w)
+	first := binary.BigEndian.Uint32(networkIP(ipnet))
+	last := binary.BigEndian.Uint32(LastIP(ipnet))
+
+	count := 0
+	buf := make([]byte, 0, len("255.255.255.255\n"))
+	for n := uint64(first); n <= uint64(last); n++ {
+		buf = append(AppendIPv4(buf[:0], uint32(n)), '\n')
+		if _, err := writer.Write(buf); err != nil {
+			return count, fmt.Errorf("%w: %w", ErrWrite, err)
+		}
+		count++
+	}
+	return count, flush(writer)
+}
+
+// flush flushes w, marking a failure as ErrWrite
+func flush(w *bufio.Writer) error {
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+	return nil
+}
+
+// AppendIPv4 appends the IPv4 address n, in network byte order, to dst in
+// dotted-decimal form, exactly as net.IP's String method writes it
+func AppendIPv4(dst []byte, n uint32) []byte {
+	dst = strconv.AppendUint(dst, uint64(n>>24), 10)
+	dst = append(dst, '.')
+	dst = strconv.AppendUint(dst, uint64(n>>16&0xff), 10)
+	dst = append(dst, '.')
+	dst = strconv.AppendUint(dst, uint64(n>>8&0xff), 10)
+	dst = append(dst, '.')
+	return strconv.AppendUint(dst, uint64(n&0xff), 10)
+}
+
+// CheckSize returns an error if ipnet is an IPv6 range too large to
+// enumerate
+func CheckSize(ipnet *net.IPNet) error {
+	ones, bits := ipnet.Mask.Size()
+	if bits == 128 && ones < MinIPv6Prefix {
+		return fmt.Errorf("IPv6 range %s is %w: prefixes shorter than /%d are not supported", ipnet, ErrRangeTooLarge, MinIPv6Prefix)
+	}
+	return nil
+}
+
+// CheckRangeSize returns an error if r is an IPv6 range holding more
+// addresses than a /MinIPv6Prefix network
+func CheckRangeSize(r Range) error {
+	limit := new(big.Int).Lsh(big.NewInt(1), 128-MinIPv6Prefix)
+	if !r.IsIPv4() && r.Size().Cmp(limit) > 0 {
+		return fmt.Errorf("IPv6 range %s is %w: ranges over %s addresses are not supported", r, ErrRangeTooLarge, limit)
+	}
+	return nil
+}
+
+// Size returns the number of addresses in ipnet, 2^(bits-prefix), without
+// iterating
+func Size(ipnet *net.IPNet) *big.Int {
+	ones, bits := ipnet.Mask.Size()
+	return new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+}
+
+// Enumerate calls fn for every address in ipnet in ascending order,
+// stopping at the first error fn returns. Each IP passed to fn is a fresh
+// copy that fn may keep.
+func Enumerate(ipnet *net.IPNet, fn func(ip net.IP) error) error {
+	return EnumerateRange(NetworkRange(ipnet), fn)
+}
+
+// EnumerateRange calls fn for every address from r.First through r.Last
+// inclusive, with the same semantics as Enumerate
+func EnumerateRange(r Range, fn func(ip net.IP) error) error {
+	// Stopping on Last rather than testing containment keeps ranges ending
+	// at the top of the address space from wrapping around
+	ip := make(net.IP, len(r.First))
+	copy(ip, r.First)
+
+	for {
+		if err := fn(ip); err != nil {
+			return err
+		}
+		if ip.Equal(r.Last) {
+			return nil
+		}
+		ip = NextIP(ip)
+	}
+}
+
+// CheckSubnetPrefix returns an error unless prefix is a valid prefix
+// length for subnets of ipnet, i.e. no shorter than ipnet's own prefix
+func CheckSubnetPrefix(ipnet *net.IPNet, prefix int) error {
+	ones, bits := ipnet.Mask.Size()
+	if prefix < ones || prefix > bits {
+		return fmt.Errorf("prefix /%d must be between /%d and /%d for %s", prefix, ones, bits, ipnet)
+	}
+	return nil
+}
+
+// EnumerateSubnets calls fn for each prefix-sized subnet of ipnet in
+// ascending order, stopping at the first error fn returns
+func EnumerateSubnets(ipnet *net.IPNet, prefix int, fn func(subnet *net.IPNet) error) error {
+	if err := CheckSubnetPrefix(ipnet, prefix); err != nil {
+		return err
+	}
+
+	_, bits := ipnet.Mask.Size()
+	mask := net.CIDRMask(prefix, bits)
+	last := LastIP(ipnet)
+	network := networkIP(ipnet)
+	ip := make(net.IP, len(network))
+	copy(ip, network)
+
+	for {
+		subnet := &net.IPNet{IP: ip, Mask: mask}
+		if err := fn(subnet); err != nil {
+			return err
+		}
+
+		// Move to the first address after this subnet
+		subnetLast := LastIP(subnet)
+		if subnetLast.Equal(last) {
+			return nil
+		}
+		ip = NextIP(subnetLast)
+	}
+}
+
+// EnumerateRangeStep calls fn for r.First and every step-th address after
+// it that is still within r, with the same semantics as Enumerate. A step
+// of 0 or 1 visits every address.
+func EnumerateRangeStep(r Range, step uint64, fn func(ip net.IP) error) error {
+	if step <= 1 {
+		return EnumerateRange(r, fn)
+	}
+
+	// Track how far the last address is from the current one so a step that
+	// would overshoot ends the walk instead of wrapping or leaving the range
+	remaining := r.Size()
+	remaining.Sub(remaining, big.NewInt(1))
+	bigStep := new(big.Int).SetUint64(step)
+
+	ip := make(net.IP, len(r.First))
+	copy(ip, r.First)
+	for {
+		if err := fn(ip); err != nil {
+			return err
+		}
+		if remaining.Cmp(bigStep) < 0 {
+			return nil
+		}
+		remaining.Sub(remaining, bigStep)
+		ip = addIP(ip, step)
+	}
+}
+
+// EnumerateRangeReverse calls fn for r.Last and every step-th address
+// below it that is still within r, in descending order, so the walk ends
+// on r.First when step divides the range evenly. Otherwise it has the same
+// semantics as EnumerateRangeStep.
+func EnumerateRangeReverse(r Range, step uint64, fn func(ip net.IP) error) error {
+	if step < 1 {
+		step = 1
+	}
+
+	// Track how far the first address is from the current one so the walk
+	// stops there instead of wrapping below zero
+	remaining := r.Size()
+	remaining.Sub(remaining, big.NewInt(1))
+	bigStep := new(big.Int).SetUint64(step)
+
+	ip := make(net.IP, len(r.Last))
+	copy(ip, r.Last)
+	for {
+		if err := fn(ip); err != nil {
+			return err
+		}
+		if remaining.Cmp(bigStep) < 0 {
+			return nil
+		}
+		remaining.Sub(remaining, bigStep)
+		ip = subIP(ip, step)
+	}
+}
+
+// LastIP returns the highest address in a network (the broadcast address
+// for IPv4)
+func LastIP(ipnet *net.IPNet) net.IP {
+	network := networkIP(ipnet)
+	ip := make(net.IP, len(network))
+	for i := range ip {
+		ip[i] = network[i] | ^ipnet.Mask[i]
+	}
+	return ip
+}
+
+// addIP returns a new IP n addresses after ip, carrying across bytes like
+// NextIP
+func addIP(ip net.IP, n uint64) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for j := len(next) - 1; j >= 0 && n > 0; j-- {
+		sum := uint64(next[j]) + n&0xff
+		next[j] = byte(sum)
+		n = n>>8 + sum>>8
+	}
+	return next
+}
+
+// NextIP returns a new IP one address after ip, leaving ip untouched. The
+// carry runs across every byte so it works for both the 4-byte IPv4 and
+// 16-byte IPv6 representations; the highest address wraps to zero.
+func NextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for j := len(next) - 1; j >= 0; j-- {
+		next[j]++
+		if next[j] > 0 {
+			break
+		}
+	}
+	return next
+}
+
+// subIP returns a new IP n addresses before ip, borrowing across bytes
+// like PrevIP
+func subIP(ip net.IP, n uint64) net.IP {
+	prev := make(net.IP, len(ip))
+	copy(prev, ip)
+	for j := len(prev) - 1; j >= 0 && n > 0; j-- {
+		diff := int(prev[j]) - int(n&0xff)
+		n >>= 8
+		if diff < 0 {
+			diff += 256
+			n++
+		}
+		prev[j] = byte(diff)
+	}
+	return prev
+}
+
+// PrevIP returns a new IP one address before ip, leaving ip untouched. It
+// mirrors NextIP, borrowing across every byte; the lowest address wraps to
+// the highest.
+func PrevIP(ip net.IP) net.IP {
+	prev := make(net.IP, len(ip))
+	copy(prev, ip)
+	for j := len(prev) - 1; j >= 0; j-- {
+		prev[j]--
+		if prev[j] != 0xff {
+			break
+		}
+	}
+	return prev
+}
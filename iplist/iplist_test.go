@@ -0,0 +1,74 @@
+package iplist
+
+import (
+	"io"
+	"net"
+	"testing"
+)
+
+// ip parses s, in its 4-byte form for IPv4
+func ip(s string) net.IP {
+	parsed := net.ParseIP(s)
+	if v4 := parsed.To4(); v4 != nil {
+		return v4
+	}
+	return parsed
+}
+
+func TestNextIP(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"192.168.0.1", "192.168.0.2"},
+		{"192.168.0.255", "192.168.1.0"},
+		{"10.255.255.255", "11.0.0.0"},
+		{"255.255.255.255", "0.0.0.0"},
+		{"2001:db8::ff", "2001:db8::100"},
+		{"2001:db8::ffff:ffff", "2001:db8::1:0:0"},
+		{"2001:db8:ffff:ffff:ffff:ffff:ffff:ffff", "2001:db9::"},
+		{"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff", "::"},
+	}
+	for _, tt := range tests {
+		in := ip(tt.in)
+		orig := append(net.IP(nil), in...)
+		got := NextIP(in)
+		if !got.Equal(ip(tt.want)) || len(got) != len(in) {
+			t.Errorf("NextIP(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+		if !in.Equal(orig) {
+			t.Errorf("NextIP(%s) changed its input to %s", tt.in, in)
+		}
+		if back := PrevIP(got); !back.Equal(orig) {
+			t.Errorf("PrevIP(%s) = %s, want %s", got, back, tt.in)
+		}
+	}
+}
+
+func TestAddSubIP(t *testing.T) {
+	base := ip("10.0.0.250")
+	if got := addIP(base, 300); !got.Equal(ip("10.0.2.38")) {
+		t.Errorf("addIP = %s, want 10.0.2.38", got)
+	}
+	if got := subIP(ip("10.0.2.38"), 300); !got.Equal(base) {
+		t.Errorf("subIP = %s, want %s", got, base)
+	}
+	if !base.Equal(ip("10.0.0.250")) {
+		t.Error("addIP changed its input")
+	}
+}
+
+func BenchmarkGenerateIPs(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := GenerateIPs("10.0.0.0/16", io.Discard); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkEnumerate(b *testing.B) {
+	_, ipnet, _ := net.ParseCIDR("10.0.0.0/16")
+	for i := 0; i < b.N; i++ {
+		Enumerate(ipnet, func(ip net.IP) error {
+			io.WriteString(io.Discard, ip.String()+"\n")
+			return nil
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package iplist
+
+import (
+	"context"
+	"fmt"
+	"net"
+)
+
+// Iterate parses cidr and streams every address in it over the returned
+// channel in ascending order, closing the channel once the last address
+// has been sent. Cancelling ctx stops the enumeration early and closes
+// the channel, so a consumer that stops reading must cancel ctx to let the
+// producing goroutine exit.
+func Iterate(ctx context.Context, cidr string) (<-chan net.IP, error) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCIDR, err)
+	}
+	if err := CheckSize(ipnet); err != nil {
+		return nil, err
+	}
+
+	ips := make(chan net.IP)
+	go func() {
+		defer close(ips)
+		Enumerate(ipnet, func(ip net.IP) error {
+			select {
+			case ips <- ip:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	return ips, nil
+}
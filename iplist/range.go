@@ -0,0 +1,142 @@
+package iplist
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+)
+
+// Range is an inclusive span of addresses from First to Last. Both ends
+// use the same representation: 4 bytes for IPv4 and 16 bytes for IPv6.
+type Range struct {
+	First net.IP
+	Last  net.IP
+}
+
+// NetworkRange returns the range covering every address in ipnet, in the
+// representation its mask implies
+func NetworkRange(ipnet *net.IPNet) Range {
+	network := networkIP(ipnet)
+	first := make(net.IP, len(network))
+	copy(first, network)
+	return Range{First: first, Last: LastIP(ipnet)}
+}
+
+// networkIP returns the address of ipnet at the length of its mask, so an
+// IPv4 network built from a 16-byte address still yields 4-byte IPs
+func networkIP(ipnet *net.IPNet) net.IP {
+	if len(ipnet.Mask) == net.IPv4len {
+		if ip4 := ipnet.IP.To4(); ip4 != nil {
+			return ip4
+		}
+	}
+	return ipnet.IP
+}
+
+// ParseRange parses a start-end range such as 192.168.1.10-192.168.1.200.
+// Both addresses must be the same IP version and start must not be after
+// end.
+func ParseRange(s string) (Range, error) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return Range{}, fmt.Errorf("%w %q: expected start-end", ErrInvalidRange, s)
+	}
+
+	first := net.ParseIP(strings.TrimSpace(parts[0]))
+	if first == nil {
+		return Range{}, fmt.Errorf("%w %q: bad start address", ErrInvalidRange, s)
+	}
+	last := net.ParseIP(strings.TrimSpace(parts[1]))
+	if last == nil {
+		return Range{}, fmt.Errorf("%w %q: bad end address", ErrInvalidRange, s)
+	}
+
+	// Normalize to the family-appropriate representation so the byte
+	// comparison and NextIP carry work on matching lengths
+	first4, last4 := first.To4(), last.To4()
+	if (first4 == nil) != (last4 == nil) {
+		return Range{}, fmt.Errorf("%w %q: start and end are different IP versions", ErrInvalidRange, s)
+	}
+	if first4 != nil {
+		first, last = first4, last4
+	}
+
+	if bytes.Compare(first, last) > 0 {
+		return Range{}, fmt.Errorf("%w %q: start address is after end address", ErrInvalidRange, s)
+	}
+
+	return Range{First: first, Last: last}, nil
+}
+
+// String returns the range in start-end form
+func (r Range) String() string {
+	return r.First.String() + "-" + r.Last.String()
+}
+
+// Size returns the number of addresses in the range
+func (r Range) Size() *big.Int {
+	size := new(big.Int).SetBytes(r.Last)
+	size.Sub(size, new(big.Int).SetBytes(r.First))
+	return size.Add(size, big.NewInt(1))
+}
+
+// IsIPv4 reports whether the range holds IPv4 addresses
+func (r Range) IsIPv4() bool {
+	return len(r.First) == net.IPv4len
+}
+
+// Contains reports whether ip falls inside the range
+func (r Range) Contains(ip net.IP) bool {
+	if r.IsIPv4() {
+		ip = ip.To4()
+	} else if ip.To4() == nil {
+		ip = ip.To16()
+	} else {
+		return false
+	}
+	return ip != nil && bytes.Compare(ip, r.First) >= 0 && bytes.Compare(ip, r.Last) <= 0
+}
+
+// At returns the address offset positions after r.First. The offset must
+// be less than r.Size().
+func (r Range) At(offset uint64) net.IP {
+	n := new(big.Int).SetBytes(r.First)
+	n.Add(n, new(big.Int).SetUint64(offset))
+	return intToIP(n, len(r.First))
+}
+
+// Split divides r into at most n contiguous sub-ranges of near-equal size,
+// in ascending order. Fewer than n are returned when r is smaller than n.
+func (r Range) Split(n int) []Range {
+	size := r.Size()
+	if n < 1 {
+		n = 1
+	}
+	if size.Cmp(big.NewInt(int64(n))) < 0 {
+		n = int(size.Int64())
+	}
+
+	// Spread the remainder over the first chunks so sizes differ by at most one
+	chunk, rem := new(big.Int).QuoRem(size, big.NewInt(int64(n)), new(big.Int))
+	parts := make([]Range, 0, n)
+	start := new(big.Int).SetBytes(r.First)
+	for i := 0; i < n; i++ {
+		end := new(big.Int).Add(start, chunk)
+		if big.NewInt(int64(i)).Cmp(rem) >= 0 {
+			end.Sub(end, big.NewInt(1))
+		}
+		parts = append(parts, Range{First: intToIP(start, len(r.First)), Last: intToIP(end, len(r.First))})
+		start = end.Add(end, big.NewInt(1))
+	}
+	return parts
+}
+
+// intToIP converts an address held as an integer back into an IP of the
+// given byte length
+func intToIP(n *big.Int, length int) net.IP {
+	ip := make(net.IP, length)
+	n.FillBytes(ip)
+	return ip
+}
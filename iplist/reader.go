@@ -0,0 +1,63 @@
+package iplist
+
+import (
+	"fmt"
+	"io"
+	"net"
+)
+
+// reader is the io.Reader returned by NewReader. It holds only its place
+// in the range and the rest of the line a short Read left unfinished.
+type reader struct {
+	next    net.IP // Next address to format, nil once the last has been
+	last    net.IP // Final address of the range
+	pending []byte // Formatted text not yet handed to a caller
+}
+
+// NewReader parses cidr and returns a reader of its addresses as text, one
+// per line in ascending order, exactly as GenerateIPs writes them. Lines
+// are formatted on demand as Read is called, so the reader can be handed
+// to io.Copy or used as an HTTP body without holding the list in memory
+// or running a goroutine.
+func NewReader(cidr string) (io.Reader, error) {
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCIDR, err)
+	}
+	if err := CheckSize(ipnet); err != nil {
+		return nil, err
+	}
+
+	r := NetworkRange(ipnet)
+	return &reader{next: r.First, last: r.Last}, nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		// Whatever is left of the previous line goes out first; a line
+		// longer than p is handed over across several calls
+		if len(r.pending) > 0 {
+			c := copy(p[n:], r.pending)
+			r.pending = r.pending[c:]
+			n += c
+			continue
+		}
+		if r.next == nil {
+			break
+		}
+
+		r.pending = append(r.pending[:0], r.next.String()...)
+		r.pending = append(r.pending, '\n')
+		if r.next.Equal(r.last) {
+			r.next = nil
+		} else {
+			r.next = NextIP(r.next)
+		}
+	}
+
+	if n == 0 && len(p) > 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,67 @@
+package iplist
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestNewReader(t *testing.T) {
+	for _, cidr := range []string{"192.168.0.0/24", "10.0.0.7/32", "255.255.255.252/30", "2001:db8::ff00/120"} {
+		var want bytes.Buffer
+		if _, err := GenerateIPs(cidr, &want); err != nil {
+			t.Fatal(err)
+		}
+
+		// Buffers smaller than a line, and sizes that don't divide one,
+		// split lines across reads
+		for _, size := range []int{1, 2, 3, 7, 16, 4096} {
+			r, err := NewReader(cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bytes.Buffer
+			buf := make([]byte, size)
+			for {
+				n, err := r.Read(buf)
+				got.Write(buf[:n])
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if n == 0 {
+					t.Fatalf("%s: Read(%d) returned nothing before EOF", cidr, size)
+				}
+			}
+			if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				t.Errorf("%s read %d bytes at a time differs from GenerateIPs", cidr, size)
+			}
+		}
+	}
+}
+
+func TestNewReaderEOF(t *testing.T) {
+	r, err := NewReader("10.0.0.0/31")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil || string(data) != "10.0.0.0\n10.0.0.1\n" {
+		t.Fatalf("got %q, %v", data, err)
+	}
+	if n, err := r.Read(make([]byte, 8)); n != 0 || err != io.EOF {
+		t.Errorf("Read after the end returned %d, %v", n, err)
+	}
+}
+
+func TestNewReaderInvalid(t *testing.T) {
+	if _, err := NewReader("10.0.0.0/33"); !errors.Is(err, ErrInvalidCIDR) {
+		t.Errorf("got %v, want ErrInvalidCIDR", err)
+	}
+	if _, err := NewReader("2001:db8::/32"); err == nil {
+		t.Error("expected a size error for a /32 IPv6 network")
+	}
+}
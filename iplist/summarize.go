@@ -0,0 +1,120 @@
+package iplist
+
+import (
+	"bytes"
+	"math/big"
+	"net"
+	"sort"
+)
+
+// CIDRs returns the fewest CIDR networks that together cover exactly the
+// addresses in r, in ascending order
+func (r Range) CIDRs() []*net.IPNet {
+	bits := len(r.First) * 8
+	start := new(big.Int).SetBytes(r.First)
+	end := new(big.Int).SetBytes(r.Last)
+	one := big.NewInt(1)
+
+	var networks []*net.IPNet
+	for start.Cmp(end) <= 0 {
+		// The block starting here can be as large as the alignment of start
+		// allows, then shrinks until it no longer runs past end
+		size := bits
+		if start.Sign() != 0 {
+			size = int(start.TrailingZeroBits())
+		}
+		remaining := new(big.Int).Sub(end, start)
+		remaining.Add(remaining, one)
+		for size > 0 && new(big.Int).Lsh(one, uint(size)).Cmp(remaining) > 0 {
+			size--
+		}
+
+		networks = append(networks, &net.IPNet{
+			IP:   intToIP(start, len(r.First)),
+			Mask: net.CIDRMask(bits-size, bits),
+		})
+		start.Add(start, new(big.Int).Lsh(one, uint(size)))
+	}
+	return networks
+}
+
+// Summarize returns the fewest CIDR networks covering exactly the given
+// addresses, the inverse of enumerating them. Duplicates are ignored and
+// the result is in ascending order with IPv4 networks before IPv6 ones.
+func Summarize(ips []net.IP) []*net.IPNet {
+	// Normalize each address to its family's representation so the two
+	// families sort apart and byte comparison orders each one
+	addrs := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			addrs = append(addrs, ip4)
+		} else if ip16 := ip.To16(); ip16 != nil {
+			addrs = append(addrs, ip16)
+		}
+	}
+	sort.Slice(addrs, func(a, b int) bool {
+		if len(addrs[a]) != len(addrs[b]) {
+			return len(addrs[a]) < len(addrs[b])
+		}
+		return bytes.Compare(addrs[a], addrs[b]) < 0
+	})
+
+	// Merge runs of consecutive addresses into ranges, then cover each range
+	// with the largest aligned blocks that fit
+	var networks []*net.IPNet
+	for i := 0; i < len(addrs); {
+		r := Range{First: addrs[i], Last: addrs[i]}
+		for i++; i < len(addrs); i++ {
+			if addrs[i].Equal(r.Last) {
+				continue
+			}
+			if len(addrs[i]) != len(r.Last) || !addrs[i].Equal(NextIP(r.Last)) {
+				break
+			}
+			r.Last = addrs[i]
+		}
+		networks = append(networks, r.CIDRs()...)
+	}
+	return networks
+}
+
+// Subtract returns the fewest CIDR networks covering the addresses of base
+// that are in none of excludes, in ascending order. It works on prefixes
+// alone, halving base wherever an exclude overlaps it, so no addresses are
+// enumerated.
+func Subtract(base *net.IPNet, excludes []*net.IPNet) []*net.IPNet {
+	network := networkIP(base)
+	ones, bits := base.Mask.Size()
+	block := &net.IPNet{IP: network.Mask(base.Mask), Mask: base.Mask}
+
+	// Networks are aligned, so two overlap exactly when one holds the other
+	covered := false
+	overlaps := false
+	for _, ex := range excludes {
+		exOnes, exBits := ex.Mask.Size()
+		if exBits != bits {
+			continue
+		}
+		if exOnes <= ones && ex.Contains(block.IP) {
+			covered = true
+			break
+		}
+		if block.Contains(ex.IP) {
+			overlaps = true
+		}
+	}
+	if covered {
+		return nil
+	}
+	if !overlaps {
+		return []*net.IPNet{block}
+	}
+
+	// Split into the two halves one bit longer and subtract from each
+	mask := net.CIDRMask(ones+1, bits)
+	upper := make(net.IP, len(block.IP))
+	copy(upper, block.IP)
+	upper[ones/8] |= 0x80 >> (ones % 8)
+	lower := Subtract(&net.IPNet{IP: block.IP, Mask: mask}, excludes)
+	return append(lower, Subtract(&net.IPNet{IP: upper, Mask: mask}, excludes)...)
+}
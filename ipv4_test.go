@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// testGenerator returns a generator for cidr configured by args, writing
+// to w, with progress discarded
+func testGenerator(tb testing.TB, w io.Writer, cidr string, args ...string) *generator {
+	tb.Helper()
+	config := testConfig(tb, append([]string{"-cidr", cidr}, args...)...)
+	_, ipnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	targets := []target{{ipnet: ipnet, span: iplist.NetworkRange(ipnet)}}
+	g := &generator{
+		ctx:       context.Background(),
+		config:    config,
+		targets:   targets,
+		perTarget: make([]int, len(targets)),
+		filters:   &filters{usable: config.usable},
+		progress:  &progress{out: io.Discard, total: targets[0].span.Size().Uint64()},
+		step:      1,
+	}
+	out, err := openOutput(config, "", w)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err := g.begin(out); err != nil {
+		tb.Fatal(err)
+	}
+	return g
+}
+
+// finish ends the format and flushes g's output
+func finish(tb testing.TB, g *generator) {
+	tb.Helper()
+	if err := g.formatter.end(g.writer); err != nil {
+		tb.Fatal(err)
+	}
+	if err := g.writer.Flush(); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// writeGeneric writes target 0 address by address through emit, the path
+// writeRange takes when fastIPv4 doesn't apply
+func writeGeneric(g *generator) error {
+	return iplist.EnumerateRangeStep(g.targets[0].span, g.step, func(ip net.IP) error {
+		return g.emit(ip, 0)
+	})
+}
+
+func TestFastPathMatchesGeneric(t *testing.T) {
+	const cidr = "10.20.0.0/16"
+	for _, format := range []string{"txt", "csv", "json"} {
+		for _, usable := range []bool{false, true} {
+			args := []string{"-format", format}
+			if usable {
+				args = append(args, "-usable")
+			}
+
+			// writeRange takes the fast path for txt and the generic one
+			// for the others, so both sides of the choice are compared
+			var fast, generic bytes.Buffer
+			g := testGenerator(t, &fast, cidr, args...)
+			if format == "txt" && !g.fastIPv4(g.targets[0].span) {
+				t.Fatal("txt output didn't take the fast path")
+			}
+			if err := g.writeRange(g.targets[0].span, 0); err != nil {
+				t.Fatal(err)
+			}
+			finish(t, g)
+
+			g = testGenerator(t, &generic, cidr, args...)
+			if err := writeGeneric(g); err != nil {
+				t.Fatal(err)
+			}
+			finish(t, g)
+
+			if !bytes.Equal(fast.Bytes(), generic.Bytes()) {
+				t.Errorf("-format %s usable=%v: fast and generic output differ (%d and %d bytes)", format, usable, fast.Len(), generic.Len())
+			}
+		}
+	}
+
+	// The library's own IPv4 loop writes the same lines
+	var fast, lib bytes.Buffer
+	g := testGenerator(t, &fast, cidr)
+	if err := g.writeRange(g.targets[0].span, 0); err != nil {
+		t.Fatal(err)
+	}
+	finish(t, g)
+	if _, err := iplist.GenerateIPs(cidr, &lib); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fast.Bytes(), lib.Bytes()) {
+		t.Error("fast path and iplist.GenerateIPs output differ")
+	}
+}
+
+func BenchmarkGenerate(b *testing.B) {
+	const cidr = "10.0.0.0/16"
+	b.Run("fast", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			g := testGenerator(b, io.Discard, cidr)
+			if err := g.writeIPv4(g.targets[0].span, 0); err != nil {
+				b.Fatal(err)
+			}
+			finish(b, g)
+		}
+	})
+	b.Run("generic", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			g := testGenerator(b, io.Discard, cidr)
+			if err := writeGeneric(g); err != nil {
+				b.Fatal(err)
+			}
+			finish(b, g)
+		}
+	})
+}
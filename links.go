@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// printLinks writes each -links sized subnet of the targets to w, or to the
+// file -output or -filename names, as the pair of host addresses of a
+// point-to-point link, "a <-> b", one link per line. A /31 (or IPv6 /127)
+// has no network or broadcast address, so both of its addresses are the
+// pair (RFC 3021); a /30 (or /126) keeps its first and last address
+// reserved and pairs the two between them.
+func printLinks(config *Config, targets []target, w io.Writer) error {
+	prefix, err := strconv.Atoi(strings.TrimPrefix(config.links, "/"))
+	if err != nil {
+		return fmt.Errorf("invalid -links prefix %q", config.links)
+	}
+
+	// Start-end ranges take part as the networks that cover them. Every
+	// link is a line, so the same size guard as a generation run applies.
+	var bases []*net.IPNet
+	links := uint64(0)
+	for _, t := range targets {
+		networks := []*net.IPNet{t.ipnet}
+		if t.ipnet == nil {
+			networks = t.span.CIDRs()
+		}
+		for _, ipnet := range networks {
+			_, bits := ipnet.Mask.Size()
+			if hostBits := bits - prefix; hostBits != 1 && hostBits != 2 {
+				return fmt.Errorf("-links takes /%d or /%d link subnets for %s, not /%d", bits-1, bits-2, t, prefix)
+			}
+			if err := iplist.CheckSubnetPrefix(ipnet, prefix); err != nil {
+				return fmt.Errorf("invalid -links prefix: %v", err)
+			}
+			ones, _ := ipnet.Mask.Size()
+			if prefix-ones >= 63 {
+				return fmt.Errorf("%s holds too many /%d links to list", ipnet, prefix)
+			}
+			links += 1 << (prefix - ones)
+		}
+		bases = append(bases, networks...)
+	}
+
+	if links > maxUnforcedIPs && !config.force {
+		return fmt.Errorf("refusing to list %d links (more than %d without confirmation); pass -force to override", links, maxUnforcedIPs)
+	}
+
+	out, err := openListOutput(config, w)
+	if err != nil {
+		return err
+	}
+	for _, base := range bases {
+		err := iplist.EnumerateSubnets(base, prefix, func(subnet *net.IPNet) error {
+			r := iplist.NetworkRange(subnet)
+			a, b := r.First, r.Last
+			if ones, bits := subnet.Mask.Size(); bits-ones == 2 {
+				a, b = iplist.NextIP(a), iplist.PrevIP(b)
+			}
+			if _, err := out.writer.WriteString(a.String() + " <-> " + b.String() + "\n"); err != nil {
+				return writeError(err)
+			}
+			return nil
+		})
+		if err != nil {
+			out.discard()
+			return err
+		}
+	}
+	return out.close()
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinks(t *testing.T) {
+	got := mustRun(t, "-cidr", "10.0.0.0/29", "-links", "/31")
+	equalLines(t, got, []string{"10.0.0.0 <-> 10.0.0.1", "10.0.0.2 <-> 10.0.0.3", "10.0.0.4 <-> 10.0.0.5", "10.0.0.6 <-> 10.0.0.7"})
+	got = mustRun(t, "-cidr", "10.0.0.0/29", "-links", "/30")
+	equalLines(t, got, []string{"10.0.0.1 <-> 10.0.0.2", "10.0.0.5 <-> 10.0.0.6"})
+	got = mustRun(t, "-cidr", "2001:db8::/126", "-links", "127")
+	equalLines(t, got, []string{"2001:db8:: <-> 2001:db8::1", "2001:db8::2 <-> 2001:db8::3"})
+
+	// A range takes part as the networks covering it
+	got = mustRun(t, "-range", "10.0.0.2-10.0.0.5", "-links", "/31")
+	equalLines(t, got, []string{"10.0.0.2 <-> 10.0.0.3", "10.0.0.4 <-> 10.0.0.5"})
+
+	for _, args := range [][]string{
+		{"-cidr", "10.0.0.0/29", "-links", "/29"},
+		{"-cidr", "10.0.0.0/29", "-links", "abc"},
+		{"-cidr", "2001:db8::/64", "-links", "/31"},
+	} {
+		if _, _, err := run(t, args...); err == nil {
+			t.Errorf("%v: expected an error", args)
+		}
+	}
+}
+
+func TestLinksOutput(t *testing.T) {
+	dir := t.TempDir()
+	stdout, _, err := run(t, "-cidr", "10.0.0.0/30", "-links", "/31", "-output", dir, "-filename", "links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "" {
+		t.Errorf("wrote %q to stdout with -output set", stdout)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "links.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	equalLines(t, string(data), []string{"10.0.0.0 <-> 10.0.0.1", "10.0.0.2 <-> 10.0.0.3"})
+
+	// -stdout wins over a named file
+	stdout, _, err = run(t, "-cidr", "10.0.0.0/30", "-links", "/31", "-output", dir, "-filename", "other", "-stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	equalLines(t, stdout, []string{"10.0.0.0 <-> 10.0.0.1", "10.0.0.2 <-> 10.0.0.3"})
+	if _, err := os.Stat(filepath.Join(dir, "other.txt")); !os.IsNotExist(err) {
+		t.Errorf("-stdout still wrote a file: %v", err)
+	}
+}
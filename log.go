@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// progressEvent is one progress update as written by -log-json
+type progressEvent struct {
+	Event     string  `json:"event"`
+	Count     uint64  `json:"count"`
+	Processed uint64  `json:"processed"`
+	Total     uint64  `json:"total"`
+	Block     int     `json:"block,omitempty"`
+	Blocks    int     `json:"blocks,omitempty"`
+	Percent   float64 `json:"percent"`
+	ElapsedMs int64   `json:"elapsed_ms"`
+	EtaMs     int64   `json:"eta_ms"`
+}
+
+// warningEvent is a warning as written by -log-json
+type warningEvent struct {
+	Event   string `json:"event"`
+	Message string `json:"message"`
+}
+
+// targetSummary is the number of addresses written from one target
+type targetSummary struct {
+	Kind  string `json:"kind"`
+	CIDR  string `json:"cidr"`
+	Count int    `json:"count"`
+}
+
+// runSummary is the outcome of a run, printed as the execution summary or
+// marshalled as a single JSON object by -log-json. Counts that only apply
+// to some options are left out of the JSON when zero, and the per-family
+// counts are broken out in the text only when both families were given.
+type runSummary struct {
+	Event        string          `json:"event"`
+	Targets      []targetSummary `json:"targets"`
+	Count        int             `json:"count"`
+	Expected     uint64          `json:"expected_total"`
+	IPv4Count    int             `json:"ipv4_count,omitempty"`
+	IPv6Count    int             `json:"ipv6_count,omitempty"`
+	Reserved     int             `json:"reserved_skipped,omitempty"`
+	Excluded     int             `json:"excluded_skipped,omitempty"`
+	Duplicates   int             `json:"duplicates_skipped,omitempty"`
+	Approximate  bool            `json:"dedupe_approximate,omitempty"`
+	FPRate       float64         `json:"dedupe_fp_rate,omitempty"`
+	NonPublic    int             `json:"reserved_ranges_skipped,omitempty"`
+	Present      int             `json:"already_present_skipped,omitempty"`
+	Unmatched    int             `json:"unmatched_skipped,omitempty"`
+	Step         int             `json:"step,omitempty"`
+	Window       string          `json:"offset_window,omitempty"`
+	Shard        string          `json:"shard,omitempty"`
+	Seed         int64           `json:"seed,omitempty"`
+	Resumed      string          `json:"resumed_after,omitempty"`
+	Prior        int             `json:"previously_written,omitempty"`
+	Checkpoint   string          `json:"checkpoint_saved,omitempty"`
+	Truncated    bool            `json:"truncated,omitempty"`
+	Interrupted  bool            `json:"interrupted,omitempty"`
+	TimedOut     bool            `json:"timed_out,omitempty"`
+	Failed       bool            `json:"failed,omitempty"`
+	Error        string          `json:"error,omitempty"`
+	ElapsedMs    int64           `json:"elapsed_ms"`
+	OutputFile   string          `json:"output_file,omitempty"`
+	WriteMode    string          `json:"write_mode,omitempty"`
+	SHA256       string          `json:"sha256,omitempty"`
+	Archive      string          `json:"archive,omitempty"`
+	OutputDir    string          `json:"output_dir,omitempty"`
+	FilesWritten int             `json:"files_written,omitempty"`
+	Files        []fileSummary   `json:"files,omitempty"`
+	IPsPerSecond float64         `json:"ips_per_second"`
+
+	elapsed time.Duration // Time taken, kept exact for the text summary
+	mixed   bool          // Targets span both IP families
+}
+
+// writeEvent writes v to w as one line of JSON. Like the text output,
+// failures to write log lines are ignored.
+func writeEvent(w io.Writer, v any) {
+	json.NewEncoder(w).Encode(v)
+}
+
+// warn writes a warning to w, as a JSON event with -log-json
+func warn(w io.Writer, config *Config, format string, args ...any) {
+	message := fmt.Sprintf(format, args...)
+	if config.logJSON {
+		writeEvent(w, warningEvent{Event: "warning", Message: message})
+		return
+	}
+	fmt.Fprintf(w, "Warning: %s\n", message)
+}
+
+// write writes the summary to w, as a JSON event with -log-json
+func (s *runSummary) write(w io.Writer, config *Config) {
+	if config.logJSON {
+		writeEvent(w, s)
+		return
+	}
+
+	fmt.Fprintf(w, "\nExecution Summary:\n")
+	fmt.Fprintf(w, "----------------\n")
+	for _, t := range s.Targets {
+		fmt.Fprintf(w, "%s: %s (%d IPs)\n", t.Kind, t.CIDR, t.Count)
+	}
+	if config.usable {
+		fmt.Fprintf(w, "Network/Broadcast Skipped: %d\n", s.Reserved)
+	}
+	if config.exclude != "" || config.exclFile != "" {
+		fmt.Fprintf(w, "Excluded IPs Skipped: %d\n", s.Excluded)
+	}
+	if config.dedupe {
+		fmt.Fprintf(w, "Duplicates Skipped: %d\n", s.Duplicates)
+	}
+	if config.approx {
+		fmt.Fprintf(w, "Duplicates Skipped: %d (approximate, %g%% false-positive rate)\n", s.Duplicates, s.FPRate*100)
+	}
+	if config.publicOnly {
+		fmt.Fprintf(w, "Reserved Ranges Skipped: %d\n", s.NonPublic)
+	}
+	if config.dedupeFile != "" {
+		fmt.Fprintf(w, "Already Present Skipped: %d\n", s.Present)
+	}
+	if config.match != "" {
+		fmt.Fprintf(w, "Unmatched IPs Skipped: %d\n", s.Unmatched)
+	}
+	if s.Step > 1 {
+		fmt.Fprintf(w, "Step: every %d IPs\n", s.Step)
+	}
+	if s.Window != "" {
+		fmt.Fprintf(w, "Offset Window: %s\n", s.Window)
+	}
+	if s.Shard != "" {
+		fmt.Fprintf(w, "Shard: %s\n", s.Shard)
+	}
+	if s.Seed != 0 {
+		fmt.Fprintf(w, "Random Seed: %d (pass -seed %d to reproduce)\n", s.Seed, s.Seed)
+	}
+	if s.Resumed != "" {
+		fmt.Fprintf(w, "Resumed After: %s (%d IPs written by earlier runs)\n", s.Resumed, s.Prior)
+	}
+	fmt.Fprintf(w, "Total IPs Generated: %d\n", s.Count)
+	fmt.Fprintf(w, "Expected Total: %d\n", s.Expected)
+	if s.mixed {
+		fmt.Fprintf(w, "  IPv4: %d\n", s.IPv4Count)
+		fmt.Fprintf(w, "  IPv6: %d\n", s.IPv6Count)
+	}
+	if s.Truncated {
+		fmt.Fprintf(w, "Output Truncated: limit of %d IPs reached\n", config.limit)
+	}
+	if s.Interrupted {
+		fmt.Fprintf(w, "Output Interrupted: stopped before completion\n")
+	}
+	if s.TimedOut {
+		fmt.Fprintf(w, "Output Time-Limited: stopped after -timeout %v\n", config.timeout)
+	}
+	if s.Failed {
+		fmt.Fprintf(w, "Output Failed: %s\n", s.Error)
+	}
+	if s.Checkpoint != "" {
+		fmt.Fprintf(w, "Checkpoint Saved: %s (run the same command again to resume)\n", s.Checkpoint)
+	}
+	fmt.Fprintf(w, "Time Taken: %v\n", s.elapsed)
+	if s.OutputFile != "" {
+		fmt.Fprintf(w, "Output File: %s\n", s.OutputFile)
+		if s.WriteMode != "" {
+			fmt.Fprintf(w, "Write Mode: %s\n", s.WriteMode)
+		}
+		if s.SHA256 != "" {
+			fmt.Fprintf(w, "SHA-256: %s\n", s.SHA256)
+		}
+	} else if s.Archive != "" {
+		fmt.Fprintf(w, "Output Archive: %s\n", s.Archive)
+		fmt.Fprintf(w, "Entries Written: %d\n", s.FilesWritten)
+	} else {
+		fmt.Fprintf(w, "Output Directory: %s\n", s.OutputDir)
+		fmt.Fprintf(w, "Files Written: %d\n", s.FilesWritten)
+		for _, f := range s.Files {
+			fmt.Fprintf(w, "  %s (%d IPs)\n", f.Path, f.Count)
+		}
+	}
+	fmt.Fprintf(w, "Average Speed: %.2f IPs/second\n", s.IPsPerSecond)
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"container/heap"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mergeChunk is how many addresses -merge sorts in memory at a time (16 MB
+// of keys) before spilling them to a temp file as a sorted run
+const mergeChunk = 1 << 20
+
+// mergeSummary is the outcome of a -merge run, as printed or written by
+// -log-json
+type mergeSummary struct {
+	Event      string `json:"event"`
+	Files      int    `json:"files"`
+	Count      int    `json:"count"`
+	Duplicates int    `json:"duplicates_skipped"`
+	OutputFile string `json:"output_file"`
+	ElapsedMs  int64  `json:"elapsed_ms"`
+}
+
+// v4InV6 is the prefix of the 16-byte form of every IPv4 address
+var v4InV6 = []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff}
+
+// keyLess orders 16-byte address keys numerically, every IPv4 address
+// before every IPv6 one
+func keyLess(a, b [16]byte) bool {
+	a4, b4 := bytes.HasPrefix(a[:], v4InV6), bytes.HasPrefix(b[:], v4InV6)
+	if a4 != b4 {
+		return a4
+	}
+	return bytes.Compare(a[:], b[:]) < 0
+}
+
+// mergeInputs expands the comma-separated -merge list, where each entry is
+// a file or a glob, into the files to read in the order given
+func mergeInputs(list string) ([]string, error) {
+	var paths []string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		matches, err := filepath.Glob(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -merge pattern %q: %v", entry, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match -merge entry %q", entry)
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// sortedRuns reads every address in paths, sorting them mergeChunk at a
+// time. A single chunk is returned in memory; otherwise each sorted chunk
+// is spilled to a temp file of packed 16-byte keys, which the caller must
+// remove.
+func sortedRuns(paths []string) ([][16]byte, []*os.File, error) {
+	var runs []*os.File
+	var keys [][16]byte
+	spill := func() error {
+		sort.Slice(keys, func(a, b int) bool { return keyLess(keys[a], keys[b]) })
+		f, err := os.CreateTemp("", "ip-list-*.run")
+		if err != nil {
+			return fmt.Errorf("error creating temp file: %v", err)
+		}
+		runs = append(runs, f)
+		w := bufio.NewWriter(f)
+		for _, k := range keys {
+			w.Write(k[:])
+		}
+		if err := w.Flush(); err != nil {
+			return fmt.Errorf("error writing temp file: %v", err)
+		}
+		keys = keys[:0]
+		return nil
+	}
+
+	for _, path := range paths {
+		err := readIPList(path, func(ip net.IP) error {
+			if len(keys) == mergeChunk {
+				if err := spill(); err != nil {
+					return err
+				}
+			}
+			keys = append(keys, [16]byte(ip.To16()))
+			return nil
+		})
+		if err != nil {
+			return nil, runs, err
+		}
+	}
+
+	if runs == nil {
+		sort.Slice(keys, func(a, b int) bool { return keyLess(keys[a], keys[b]) })
+		return keys, nil, nil
+	}
+	if len(keys) > 0 {
+		if err := spill(); err != nil {
+			return nil, runs, err
+		}
+	}
+	return nil, runs, nil
+}
+
+// readIPList calls fn for each address listed in the file at path, one per
+// line, reading through gzip for a .gz file. Blank lines and # comments
+// such as -group headers are ignored.
+func readIPList(path string, fn func(ip net.IP) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening %s: %v", path, err)
+	}
+	defer file.Close()
+
+	in := io.Reader(file)
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %v", path, err)
+		}
+		defer gz.Close()
+		in = gz
+	}
+
+	scanner := bufio.NewScanner(in)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		ip := net.ParseIP(line)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address %q in %s line %d", line, path, lineNum)
+		}
+		if err := fn(ip); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading %s: %v", path, err)
+	}
+	return nil
+}
+
+// runReader is one sorted run being merged, positioned on its next key
+type runReader struct {
+	in  *bufio.Reader
+	key [16]byte
+}
+
+// runHeap orders runs by their next key, the smallest on top
+type runHeap []*runReader
+
+func (h runHeap) Len() int           { return len(h) }
+func (h runHeap) Less(a, b int) bool { return keyLess(h[a].key, h[b].key) }
+func (h runHeap) Swap(a, b int)      { h[a], h[b] = h[b], h[a] }
+func (h *runHeap) Push(x any)        { *h = append(*h, x.(*runReader)) }
+func (h *runHeap) Pop() any {
+	old := *h
+	r := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return r
+}
+
+// mergeRuns calls fn for each key of the sorted run files in ascending
+// order, reading every run in step so only one key per run is in memory
+func mergeRuns(runs []*os.File, fn func(key [16]byte) error) error {
+	h := &runHeap{}
+	for _, f := range runs {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("error reading temp file: %v", err)
+		}
+		r := &runReader{in: bufio.NewReader(f)}
+		if _, err := io.ReadFull(r.in, r.key[:]); err == nil {
+			heap.Push(h, r)
+		}
+	}
+
+	for h.Len() > 0 {
+		r := (*h)[0]
+		if err := fn(r.key); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(r.in, r.key[:]); err != nil {
+			if err != io.EOF {
+				return fmt.Errorf("error reading temp file: %v", err)
+			}
+			heap.Pop(h)
+			continue
+		}
+		heap.Fix(h, 0)
+	}
+	return nil
+}
+
+// mergeFiles combines the IP lists named by -merge into one output in the
+// chosen format, sorted numerically with IPv4 before IPv6 and with each
+// address written once. Lists too large to sort in memory are sorted in
+// chunks spilled to temp files and merged from there.
+func mergeFiles(config *Config, stdout, logOut io.Writer) (err error) {
+	start := time.Now()
+	paths, err := mergeInputs(config.merge)
+	if err != nil {
+		return err
+	}
+
+	keys, runs, err := sortedRuns(paths)
+	defer func() {
+		for _, f := range runs {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	if err != nil {
+		return err
+	}
+
+	// Resolve the destination the way a generation run would, named after
+	// the merge unless -filename is given
+	path := ""
+	if !config.stdout && config.outputDir != "-" {
+		if err := prepareOutputDir(config); err != nil {
+			return err
+		}
+		repeatable := config.filename != "" || config.noTime
+		if config.filename == "" {
+			config.filename = "ip_list_merged_" + start.Format("20060102_150405")
+			if config.noTime {
+				config.filename = "ip_list_merged"
+			}
+		}
+		path = filepath.Join(config.outputDir, outputFilename(config))
+		if repeatable {
+			if err := checkOverwrite(config, path); err != nil {
+				return err
+			}
+		}
+	}
+	out, err := openOutput(config, path, stdout)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.discard()
+		}
+	}()
+
+	f := newFormatter(config, nil)
+	if err := f.begin(out.writer); err != nil {
+		return writeError(err)
+	}
+	summary := mergeSummary{Event: "merge_summary", Files: len(paths), OutputFile: out.path}
+	var prev [16]byte
+	write := func(key [16]byte) error {
+		if summary.Count+summary.Duplicates > 0 && key == prev {
+			summary.Duplicates++
+			return nil
+		}
+		prev = key
+		ip := net.IP(key[:])
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		if err := f.writeIP(out.writer, ip); err != nil {
+			return writeError(err)
+		}
+		summary.Count++
+		return nil
+	}
+	if runs != nil {
+		err = mergeRuns(runs, write)
+	} else {
+		for _, key := range keys {
+			if err = write(key); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := f.end(out.writer); err != nil {
+		return writeError(err)
+	}
+	if err := out.close(); err != nil {
+		return err
+	}
+
+	summary.ElapsedMs = time.Since(start).Milliseconds()
+	if config.logJSON {
+		writeEvent(logOut, summary)
+		return nil
+	}
+	fmt.Fprintf(logOut, "\nMerge Summary:\n")
+	fmt.Fprintf(logOut, "----------------\n")
+	fmt.Fprintf(logOut, "Files Merged: %d\n", summary.Files)
+	fmt.Fprintf(logOut, "Duplicates Skipped: %d\n", summary.Duplicates)
+	fmt.Fprintf(logOut, "Total IPs Written: %d\n", summary.Count)
+	fmt.Fprintf(logOut, "Time Taken: %v\n", time.Since(start))
+	fmt.Fprintf(logOut, "Output File: %s\n", summary.OutputFile)
+	return nil
+}
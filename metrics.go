@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// writeMetrics writes the outcome of a run to path in the Prometheus text
+// exposition format, for a node_exporter textfile collector to pick up.
+// Every value describes this run alone and is replaced by the next one, so
+// they are all gauges, none of them counters. The file is replaced whole,
+// so a collector scraping mid-write never sees half of it.
+func writeMetrics(path string, s *runSummary, finished time.Time) error {
+	var b strings.Builder
+	metric := func(name, kind, help string, samples ...string) {
+		fmt.Fprintf(&b, "# HELP %s %s\n", name, help)
+		fmt.Fprintf(&b, "# TYPE %s %s\n", name, kind)
+		for _, sample := range samples {
+			fmt.Fprintf(&b, "%s%s\n", name, sample)
+		}
+	}
+	value := func(v any) string { return fmt.Sprintf(" %v", v) }
+	success := 1
+	if s.Failed || s.Interrupted {
+		success = 0
+	}
+
+	metric("ip_list_generated_ips", "gauge", "IP addresses written by the run.", value(s.Count))
+	metric("ip_list_expected_ips", "gauge", "IP addresses held by the requested ranges.", value(s.Expected))
+	metric("ip_list_skipped_ips", "gauge", "IP addresses left out of the output, by reason.",
+		fmt.Sprintf(`{reason="reserved"} %d`, s.Reserved),
+		fmt.Sprintf(`{reason="excluded"} %d`, s.Excluded),
+		fmt.Sprintf(`{reason="duplicate"} %d`, s.Duplicates),
+		fmt.Sprintf(`{reason="non_public"} %d`, s.NonPublic),
+		fmt.Sprintf(`{reason="already_present"} %d`, s.Present),
+		fmt.Sprintf(`{reason="unmatched"} %d`, s.Unmatched))
+	metric("ip_list_duration_seconds", "gauge", "Time the run took.", value(s.elapsed.Seconds()))
+	metric("ip_list_ips_per_second", "gauge", "Average rate IP addresses were written at.", value(s.IPsPerSecond))
+	metric("ip_list_success", "gauge", "Whether the run finished without failing or being interrupted.", value(success))
+	metric("ip_list_last_run_timestamp_seconds", "gauge", "Unix time the run finished.", value(finished.Unix()))
+
+	if err := replaceFile(path, []byte(b.String())); err != nil {
+		return fmt.Errorf("error writing metrics file: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// sampleLine matches a sample of the text exposition format: a metric name,
+// optional labels and a value
+var sampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{[a-zA-Z_][a-zA-Z0-9_]*="[^"]*"(,[a-zA-Z_][a-zA-Z0-9_]*="[^"]*")*\})? (\S+)$`)
+
+func TestMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.prom")
+	if _, _, err := run(t, "-cidr", "10.0.0.0/28", "-exclude", "10.0.0.0/30", "-stdout", "-metrics-file", path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every sample follows the HELP and TYPE lines of its metric, and
+	// every metric is a gauge
+	samples := make(map[string]string)
+	helped, typed := make(map[string]bool), make(map[string]bool)
+	for _, line := range lines(string(data)) {
+		if rest, ok := strings.CutPrefix(line, "# HELP "); ok {
+			name, help, _ := strings.Cut(rest, " ")
+			if help == "" {
+				t.Errorf("%s has no help text", name)
+			}
+			helped[name] = true
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, kind, _ := strings.Cut(rest, " ")
+			if kind != "gauge" {
+				t.Errorf("%s is a %s, want a gauge", name, kind)
+			}
+			if strings.HasSuffix(name, "_total") {
+				t.Errorf("gauge %s has a counter's _total suffix", name)
+			}
+			typed[name] = true
+			continue
+		}
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("malformed line %q", line)
+			continue
+		}
+		if !helped[m[1]] || !typed[m[1]] {
+			t.Errorf("sample %q comes before the HELP and TYPE of its metric", line)
+		}
+		if _, err := strconv.ParseFloat(m[4], 64); err != nil {
+			t.Errorf("sample %q has a non-numeric value", line)
+		}
+		samples[m[1]+m[2]] = m[4]
+	}
+
+	for name, want := range map[string]string{
+		"ip_list_generated_ips":                   "12",
+		"ip_list_expected_ips":                    "16",
+		`ip_list_skipped_ips{reason="excluded"}`:  "4",
+		`ip_list_skipped_ips{reason="duplicate"}`: "0",
+		"ip_list_success":                         "1",
+	} {
+		if got := samples[name]; got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{"ip_list_duration_seconds", "ip_list_ips_per_second", "ip_list_last_run_timestamp_seconds"} {
+		if _, ok := samples[name]; !ok {
+			t.Errorf("no %s sample", name)
+		}
+	}
+}
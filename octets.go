@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// parseOctets parses a -last-octet list such as "1,10,254" into its
+// distinct values in ascending order
+func parseOctets(list string) ([]uint32, error) {
+	seen := make(map[uint32]bool)
+	var octets []uint32
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		n, err := strconv.ParseUint(entry, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -last-octet value %q: must be 0-255", entry)
+		}
+		if !seen[uint32(n)] {
+			seen[uint32(n)] = true
+			octets = append(octets, uint32(n))
+		}
+	}
+	sort.Slice(octets, func(a, b int) bool { return octets[a] < octets[b] })
+	return octets, nil
+}
+
+// countOctets returns how many addresses of the IPv4 span end in one of
+// octets, worked out per octet rather than by enumerating
+func countOctets(span iplist.Range, octets []uint32) uint64 {
+	first := int64(binary.BigEndian.Uint32(span.First))
+	last := int64(binary.BigEndian.Uint32(span.Last))
+
+	// upTo counts the addresses from 0 through x ending in o
+	upTo := func(x, o int64) int64 {
+		if x < o {
+			return 0
+		}
+		return (x-o)/256 + 1
+	}
+
+	count := int64(0)
+	for _, o := range octets {
+		count += upTo(last, int64(o)) - upTo(first-1, int64(o))
+	}
+	return uint64(count)
+}
+
+// writeOctets writes the addresses of the IPv4 span, part of target i, that
+// end in one of -last-octet's values. Each 256-address block is visited once
+// and the matching addresses in it are built directly, so a /16 takes 256
+// blocks rather than 65536 addresses.
+func (g *generator) writeOctets(span iplist.Range, i int) error {
+	first := binary.BigEndian.Uint32(span.First)
+	last := binary.BigEndian.Uint32(span.Last)
+	blocks := uint64(last>>8) - uint64(first>>8) + 1
+
+	for b := uint64(0); b < blocks; b++ {
+		block := first>>8 + uint32(b)
+		if g.config.reverse {
+			block = last>>8 - uint32(b)
+		}
+		for j := range g.octets {
+			o := g.octets[j]
+			if g.config.reverse {
+				o = g.octets[len(g.octets)-1-j]
+			}
+			n := block<<8 | o
+			if n < first || n > last {
+				continue
+			}
+			ip := make(net.IP, net.IPv4len)
+			binary.BigEndian.PutUint32(ip, n)
+			if err := g.emit(ip, i); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
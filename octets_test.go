@@ -0,0 +1,45 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+func TestLastOctet(t *testing.T) {
+	got := mustRun(t, "-cidr", "10.0.0.0/23", "-last-octet", "254,1,1", "-stdout")
+	equalLines(t, got, []string{"10.0.0.1", "10.0.0.254", "10.0.1.1", "10.0.1.254"})
+
+	got = mustRun(t, "-cidr", "10.0.0.0/23", "-last-octet", "1,254", "-reverse", "-stdout")
+	equalLines(t, got, []string{"10.0.1.254", "10.0.1.1", "10.0.0.254", "10.0.0.1"})
+
+	got = mustRun(t, "-range", "10.0.0.5-10.0.1.0", "-last-octet", "0,5", "-stdout")
+	equalLines(t, got, []string{"10.0.0.5", "10.0.1.0"})
+}
+
+func TestLastOctetNoMatch(t *testing.T) {
+	if got := mustRun(t, "-cidr", "10.0.0.0/30", "-last-octet", "200", "-stdout"); got != "" {
+		t.Errorf("got %q, want no IPs", got)
+	}
+}
+
+func TestLastOctetInvalid(t *testing.T) {
+	for _, list := range []string{"256", "-1", "a", "1,,2"} {
+		if _, _, err := run(t, "-cidr", "10.0.0.0/24", "-last-octet", list, "-stdout"); err == nil {
+			t.Errorf("-last-octet %q: expected an error", list)
+		}
+	}
+	if _, _, err := run(t, "-cidr", "2001:db8::/120", "-last-octet", "1", "-stdout"); err == nil {
+		t.Error("expected an error for an IPv6 range")
+	}
+}
+
+func TestCountOctets(t *testing.T) {
+	span, err := iplist.ParseRange("10.0.0.5-10.0.3.4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := countOctets(span, []uint32{0, 4, 5, 255}); got != 12 {
+		t.Errorf("got %d, want 12", got)
+	}
+}
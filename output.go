@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// output is an open destination for generated addresses: a file or stdout,
+// optionally gzip-compressed, behind a buffered writer
+type output struct {
+	path     string        // Full file path, or "stdout"
+	tmp      string        // Temp file renamed to path on close, empty when writing in place
+	appended bool          // Whether the file already had content
+	pipe     bool          // Whether the path is a named pipe fed live
+	file     *os.File      // Open file, nil when writing to a stream
+	gz       *gzip.Writer  // Compressor, nil unless -gzip is set
+	hash     hash.Hash     // SHA-256 of the bytes written to file, nil unless -checksum is set
+	checksum string        // Hex digest, set by close when hashing
+	writer   *bufio.Writer // Buffered writer at the top of the stack
+	closed   bool          // Set once close has run
+}
+
+// openOutput opens the file at path, or uses stream when path is empty,
+// and builds the writer stack on top of it
+func openOutput(config *Config, path string, stream io.Writer) (*output, error) {
+	o := &output{path: "stdout"}
+	out := stream
+
+	if path != "" {
+		// Create and open output file, keeping existing content when
+		// appending. A named pipe is opened for writing as it is, which
+		// blocks until a reader attaches, rather than replaced by a file.
+		var err error
+		if isNamedPipe(path) {
+			o.pipe = true
+			o.file, err = os.OpenFile(path, os.O_WRONLY, 0)
+		} else if config.append {
+			o.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		} else {
+			// Write a fresh file under a temp name and rename it once it
+			// is complete, so a file at path is never half-written
+			o.tmp = path + ".tmp"
+			o.file, err = os.Create(o.tmp)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error creating file: %w: %v", iplist.ErrNotWritable, err)
+		}
+		o.path = path
+		out = o.file
+
+		// Note whether there was earlier content to add to
+		if config.append && !o.pipe {
+			if info, err := o.file.Stat(); err == nil && info.Size() > 0 {
+				o.appended = true
+			}
+		}
+	}
+
+	// Hash exactly the bytes that reach the file, after compression, so
+	// the sidecar verifies the file itself with sha256sum -c
+	if config.checksum && o.file != nil {
+		o.hash = sha256.New()
+		out = io.MultiWriter(out, o.hash)
+
+		// A resumed -checkpoint run appends to what earlier runs wrote,
+		// which the digest has to cover as well
+		if o.appended {
+			if err := hashFile(o.hash, path); err != nil {
+				o.file.Close()
+				return nil, err
+			}
+		}
+	}
+
+	// Compress the stream if requested. The buffered writer sits on top of
+	// the gzip writer so writes are still batched.
+	if config.gzip {
+		o.gz = gzip.NewWriter(out)
+		out = o.gz
+	}
+
+	// A tee copies the addresses to the stream as they go into the file,
+	// uncompressed so they can be piped straight into another tool
+	if config.tee && path != "" && stream != nil {
+		out = io.MultiWriter(out, stream)
+	}
+
+	// Create buffered writer for better performance
+	o.writer = bufio.NewWriter(out)
+	return o, nil
+}
+
+// openListOutput opens the destination of a run that writes a list without
+// enumerating addresses, such as -subtract or -links. The list goes to stdout unless
+// -output or -filename names a file, which is then opened the way a
+// generation run would open it.
+func openListOutput(config *Config, stdout io.Writer) (*output, error) {
+	if config.stdout || config.outputDir == "-" || (config.outputDir == "" && config.filename == "") {
+		return openOutput(config, "", stdout)
+	}
+	if err := prepareOutputDir(config); err != nil {
+		return nil, err
+	}
+	repeatable := config.filename != "" || config.noTime
+	path := filepath.Join(config.outputDir, outputFilename(config))
+	if repeatable {
+		if err := checkOverwrite(config, path); err != nil {
+			return nil, err
+		}
+	}
+	return openOutput(config, path, stdout)
+}
+
+// hashFile adds the current contents of the file at path to h
+func hashFile(h hash.Hash, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error reading output file for checksum: %v", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("error reading output file for checksum: %v", err)
+	}
+	return nil
+}
+
+// close flushes the buffered writer, closes the gzip stream and then the
+// file, in that order so the archive isn't truncated, renames a temp file
+// to its final path and writes the checksum sidecar if one was requested.
+// Calling it again is a no-op.
+func (o *output) close() error {
+	if o.closed {
+		return nil
+	}
+	o.closed = true
+
+	err := o.writer.Flush()
+	if o.gz != nil {
+		if gzErr := o.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if o.file != nil {
+		if fileErr := o.file.Close(); err == nil {
+			err = fileErr
+		}
+	}
+	if err != nil {
+		if o.tmp != "" {
+			os.Remove(o.tmp)
+		}
+		return writeError(err)
+	}
+
+	// Move the finished file into place
+	if o.tmp != "" {
+		if err := os.Rename(o.tmp, o.path); err != nil {
+			os.Remove(o.tmp)
+			return fmt.Errorf("error renaming output file: %v", err)
+		}
+	}
+
+	// Record the digest next to the file in sha256sum format
+	if o.hash != nil {
+		o.checksum = hex.EncodeToString(o.hash.Sum(nil))
+		line := o.checksum + "  " + filepath.Base(o.path) + "\n"
+		if err := os.WriteFile(o.path+".sha256", []byte(line), 0644); err != nil {
+			return fmt.Errorf("error writing checksum file: %v", err)
+		}
+	}
+	return nil
+}
+
+// discard abandons an output that failed part way: a temp file is closed
+// and removed so nothing appears at the final path, while output written
+// in place is flushed as far as it got. It is a no-op after close, so it
+// can be deferred for error paths.
+func (o *output) discard() {
+	if o.closed {
+		return
+	}
+	if o.tmp == "" {
+		o.close()
+		return
+	}
+	o.closed = true
+	o.file.Close()
+	os.Remove(o.tmp)
+}
+
+// isNamedPipe reports whether path exists and is a named pipe (FIFO)
+func isNamedPipe(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+// writeError describes a failed write to the output, calling out a reader
+// that closed its end of a pipe before the run finished
+func writeError(err error) error {
+	if errors.Is(err, syscall.EPIPE) {
+		return fmt.Errorf("error writing to file: reader closed the pipe before all IPs were written")
+	}
+	if errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("error writing to file: %w", errDiskFull)
+	}
+	return fmt.Errorf("error writing to file: %v", err)
+}
+
+// errDiskFull is the cause of a write error when the disk ran out of space
+var errDiskFull = errors.New("disk full")
+
+// failure adds how far the run got to a disk-full error, which on a large
+// range can strike long after the start, and says whether the incomplete
+// file was removed. Other errors are returned unchanged.
+func (g *generator) failure(err error) error {
+	if !errors.Is(err, errDiskFull) {
+		return err
+	}
+	cleanup := "the output holds what was written before it filled"
+	if (g.out != nil && g.out.tmp != "") || g.archive != nil {
+		cleanup = "the incomplete file was removed"
+	}
+	return fmt.Errorf("%v after %d IPs were written (%d processed); %s", err, g.tally.written, g.progress.processed.Load(), cleanup)
+}
+
+// replaceFile writes data to path through a temp file beside it that is
+// renamed into place, so anyone reading path sees either the old content
+// or all of the new, never part of it
+func replaceFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".ip-list-"+filepath.Base(path)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+func TestErrNotWritable(t *testing.T) {
+	// A regular file where the output directory should be can't be
+	// created or written into, even by root
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err := run(t, "-cidr", "10.0.0.0/30", "-output", filepath.Join(file, "sub"))
+	if !errors.Is(err, iplist.ErrNotWritable) {
+		t.Errorf("output directory under a file: got %v, want ErrNotWritable", err)
+	}
+	if err := validatePath(file); !errors.Is(err, iplist.ErrNotWritable) {
+		t.Errorf("validatePath on a file: got %v, want ErrNotWritable", err)
+	}
+	if _, err := openOutput(&Config{}, filepath.Join(dir, "missing", "ips.txt"), nil); !errors.Is(err, iplist.ErrNotWritable) {
+		t.Errorf("openOutput in a missing directory: got %v, want ErrNotWritable", err)
+	}
+}
+
+func TestErrInvalidCIDR(t *testing.T) {
+	_, _, err := run(t, "-cidr", "10.0.0.0/33", "-stdout")
+	if !errors.Is(err, iplist.ErrInvalidCIDR) {
+		t.Errorf("-cidr: got %v, want ErrInvalidCIDR", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "cidrs.txt")
+	if err := os.WriteFile(path, []byte("10.0.0.0/24\nbogus\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = run(t, "-cidr-file", path, "-stdout")
+	if !errors.Is(err, iplist.ErrInvalidCIDR) {
+		t.Errorf("-cidr-file: got %v, want ErrInvalidCIDR", err)
+	}
+
+	_, _, err = run(t, "-range", "10.0.0.9-10.0.0.1", "-stdout")
+	if !errors.Is(err, iplist.ErrInvalidRange) {
+		t.Errorf("-range: got %v, want ErrInvalidRange", err)
+	}
+}
+
+func TestTee(t *testing.T) {
+	for _, args := range [][]string{
+		{"-format", "txt"},
+		{"-format", "csv"},
+		{"-format", "json", "-usable"},
+		{"-format", "hex", "-exclude", "10.0.0.8/30"},
+	} {
+		dir := t.TempDir()
+		stdout, stderr, err := run(t, append([]string{"-cidr", "10.0.0.0/28", "-tee", "-output", dir, "-filename", "ips"}, args...)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		matches, _ := filepath.Glob(filepath.Join(dir, "ips.*"))
+		if len(matches) != 1 {
+			t.Fatalf("%v: got files %q, want one", args, matches)
+		}
+		data, err := os.ReadFile(matches[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stdout != string(data) {
+			t.Errorf("%v: stdout %q differs from the file %q", args, stdout, data)
+		}
+
+		// The status moves to stderr out of the way of the copy
+		if !strings.Contains(stderr, "Execution Summary:") {
+			t.Errorf("%v: no summary on stderr:\n%s", args, stderr)
+		}
+	}
+
+	// The copy on stdout is uncompressed even when the file is gzipped
+	dir := t.TempDir()
+	stdout, _, err := run(t, "-cidr", "10.0.0.0/28", "-tee", "-gzip", "-output", dir, "-filename", "ips")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(filepath.Join(dir, "ips.txt.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal([]byte(stdout), data) || len(lines(stdout)) != 16 {
+		t.Errorf("stdout %q differs from the decompressed file %q", stdout, data)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// errAborted stops a worker after another worker has failed
+var errAborted = errors.New("aborted")
+
+// writeParallel splits target index into contiguous chunks, enumerates each in
+// its own goroutine into a temp file, then copies the temp files to writer
+// in order so the output stays ascending. Any worker failure or
+// cancellation aborts the whole target, so none of it is written, and the
+// temp files are always removed.
+func (g *generator) writeParallel(index int, workers int) error {
+	t := g.targets[index]
+	chunks := t.span.Split(workers)
+	files := make([]*os.File, len(chunks))
+	tallies := make([]tally, len(chunks))
+	errs := make([]error, len(chunks))
+
+	// Remove temp files however we return
+	defer func() {
+		for _, f := range files {
+			if f != nil {
+				f.Close()
+				os.Remove(f.Name())
+			}
+		}
+	}()
+
+	for i := range chunks {
+		f, err := os.CreateTemp("", "ip-list-*.part")
+		if err != nil {
+			return fmt.Errorf("error creating temp file: %v", err)
+		}
+		files[i] = f
+	}
+
+	// Let the remaining workers bail out once one has failed
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		wg.Add(1)
+		go func(i int, chunk iplist.Range) {
+			defer wg.Done()
+
+			w := bufio.NewWriter(files[i])
+			visited := 0
+			err := iplist.EnumerateRange(chunk, func(ip net.IP) error {
+				if failed.Load() {
+					return errAborted
+				}
+				if visited++; visited%progressInterval == 0 {
+					if err := g.ctx.Err(); err != nil {
+						return err
+					}
+				}
+				if !g.filters.admit(ip, t, &tallies[i]) {
+					g.progress.step(false)
+					return nil
+				}
+				if _, err := w.WriteString(ip.String() + "\n"); err != nil {
+					return fmt.Errorf("error writing temp file: %v", err)
+				}
+				tallies[i].written++
+				g.progress.step(true)
+				return nil
+			})
+			if err == nil {
+				err = w.Flush()
+			}
+			if err != nil {
+				errs[i] = err
+				failed.Store(true)
+			}
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	// Report the first real failure rather than a worker that merely stopped
+	for _, err := range errs {
+		if err != nil && err != errAborted {
+			return err
+		}
+	}
+
+	// Join the chunks in address order
+	for i, f := range files {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("error reading temp file: %v", err)
+		}
+		if _, err := io.Copy(g.writer, f); err != nil {
+			return writeError(err)
+		}
+		g.tally.add(tallies[i])
+		g.perTarget[index] += tallies[i].written
+	}
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"io"
+	"testing"
+)
+
+func TestParallelMatchesSerial(t *testing.T) {
+	for _, args := range [][]string{
+		{"-cidr", "10.20.0.0/16"},
+		{"-cidr", "10.20.0.0/16", "-usable", "-exclude", "10.20.7.0/24,10.20.200.128/25"},
+		{"-cidr", "192.168.0.0/30,10.0.0.0/20", "-public-only"},
+		{"-range", "10.0.0.3-10.0.3.250"},
+	} {
+		serial := mustRun(t, append(args, "-stdout")...)
+		for _, workers := range []string{"2", "3", "8"} {
+			parallel := mustRun(t, append(args, "-stdout", "-workers", workers)...)
+			if parallel != serial {
+				t.Errorf("%v -workers %s: output differs from serial (%d and %d bytes)", args, workers, len(parallel), len(serial))
+			}
+		}
+	}
+}
+
+func BenchmarkWriteParallel(b *testing.B) {
+	const cidr = "10.0.0.0/16"
+	b.Run("serial", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			g := testGenerator(b, io.Discard, cidr)
+			if err := writeGeneric(g); err != nil {
+				b.Fatal(err)
+			}
+			finish(b, g)
+		}
+	})
+	b.Run("parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			g := testGenerator(b, io.Discard, cidr)
+			if err := g.writeParallel(0, 4); err != nil {
+				b.Fatal(err)
+			}
+			finish(b, g)
+		}
+	})
+}
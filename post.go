@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// headers collects repeated -post-header flags
+type headers []string
+
+func (h *headers) String() string { return strings.Join(*h, ", ") }
+
+func (h *headers) Set(value string) error {
+	name, _, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("expected Name: value, got %q", value)
+	}
+	*h = append(*h, value)
+	return nil
+}
+
+// contentTypes are the Content-Type sent with each format under -post-url
+var contentTypes = map[string]string{
+	"txt":      "text/plain; charset=utf-8",
+	"json":     "application/json",
+	"csv":      "text/csv",
+	"tsv":      "text/tab-separated-values",
+	"jsonl":    "application/x-ndjson",
+	"int":      "text/plain; charset=utf-8",
+	"hex":      "text/plain; charset=utf-8",
+	"binary":   "application/octet-stream",
+	"range":    "text/plain; charset=utf-8",
+	"nmap":     "text/plain; charset=utf-8",
+	"cidr":     "text/plain; charset=utf-8",
+	"cisco":    "text/plain; charset=utf-8",
+	"juniper":  "text/plain; charset=utf-8",
+	"base64":   "text/plain; charset=utf-8",
+	"enriched": "text/csv",
+}
+
+// post streams the output as the body of an HTTP POST request while it is
+// generated. The request runs in its own goroutine reading from a pipe, so
+// nothing is held in memory beyond the buffered writer.
+type post struct {
+	url    string         // Collector the list is sent to
+	body   *io.PipeWriter // Write end of the request body
+	done   chan error     // Outcome of the request, sent once
+	status string         // Response status, set before a successful outcome is sent
+}
+
+// startPost begins the POST request to config.postURL with the format's
+// Content-Type and any -post-header values
+func startPost(ctx context.Context, config *Config) (*post, error) {
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.postURL, pr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -post-url: %v", err)
+	}
+	req.Header.Set("Content-Type", contentTypes[config.format])
+	if config.gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	for _, h := range config.postHeader {
+		name, value, _ := strings.Cut(h, ":")
+		req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	p := &post{url: config.postURL, body: pw, done: make(chan error, 1)}
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			err = fmt.Errorf("error posting to %s: %v", p.url, err)
+			pr.CloseWithError(err)
+			p.done <- err
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+
+		// A collector that rejects the upload may answer before reading it
+		// all, so unblock the writer with the reason
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			err = fmt.Errorf("error posting to %s: server responded %s", p.url, resp.Status)
+			pr.CloseWithError(err)
+			p.done <- err
+			return
+		}
+		p.status = resp.Status
+		p.done <- nil
+	}()
+	return p, nil
+}
+
+// finish ends the request body, cleanly if the run succeeded or was
+// stopped and as aborted otherwise, then waits for the response. A failed
+// request explains a failed write better than the write error itself, so
+// its error is returned in preference to err.
+func (p *post) finish(err error) error {
+	if err != nil && !stopped(err) {
+		p.body.CloseWithError(err)
+	} else {
+		p.body.Close()
+	}
+	if postErr := <-p.done; postErr != nil {
+		return postErr
+	}
+	return err
+}
+
+// abort cancels the body of a request that finish was never called for,
+// so the request goroutine isn't left waiting on early error paths
+func (p *post) abort() {
+	p.body.CloseWithError(errors.New("generation failed"))
+}
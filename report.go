@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// runReport is the metadata of a run written by -report, for pipelines
+// that want to know what was generated without reading the list itself
+type runReport struct {
+	Inputs       []string      `json:"inputs"`
+	Format       string        `json:"format"`
+	Count        int           `json:"count"`
+	Expected     uint64        `json:"expected_total"`
+	Skipped      skippedCounts `json:"skipped"`
+	Status       string        `json:"status"`
+	Error        string        `json:"error,omitempty"`
+	StartedAt    time.Time     `json:"started_at"`
+	DurationMs   int64         `json:"duration_ms"`
+	IPsPerSecond float64       `json:"ips_per_second"`
+	OutputFile   string        `json:"output_file,omitempty"`
+	OutputDir    string        `json:"output_dir,omitempty"`
+	Archive      string        `json:"archive,omitempty"`
+	FilesWritten int           `json:"files_written,omitempty"`
+	SHA256       string        `json:"sha256,omitempty"`
+}
+
+// skippedCounts breaks down the addresses a run left out, by reason
+type skippedCounts struct {
+	Reserved   int `json:"reserved"`
+	Excluded   int `json:"excluded"`
+	Duplicates int `json:"duplicates"`
+	NonPublic  int `json:"reserved_ranges"`
+	Present    int `json:"already_present"`
+	Unmatched  int `json:"unmatched"`
+}
+
+// newRunReport builds the report of a run from its summary. Every count is
+// included, zero or not, so the report always has the same shape.
+func newRunReport(config *Config, s *runSummary, finished time.Time) runReport {
+	r := runReport{
+		Inputs:   make([]string, len(s.Targets)),
+		Format:   config.format,
+		Count:    s.Count,
+		Expected: s.Expected,
+		Skipped: skippedCounts{
+			Reserved:   s.Reserved,
+			Excluded:   s.Excluded,
+			Duplicates: s.Duplicates,
+			NonPublic:  s.NonPublic,
+			Present:    s.Present,
+			Unmatched:  s.Unmatched,
+		},
+		Status:       "completed",
+		Error:        s.Error,
+		StartedAt:    finished.Add(-s.elapsed).UTC(),
+		DurationMs:   s.ElapsedMs,
+		IPsPerSecond: s.IPsPerSecond,
+		OutputFile:   s.OutputFile,
+		OutputDir:    s.OutputDir,
+		Archive:      s.Archive,
+		FilesWritten: s.FilesWritten,
+		SHA256:       s.SHA256,
+	}
+	for i, t := range s.Targets {
+		r.Inputs[i] = t.CIDR
+	}
+	switch {
+	case s.Failed:
+		r.Status = "failed"
+	case s.Interrupted:
+		r.Status = "interrupted"
+	case s.TimedOut:
+		r.Status = "timed_out"
+	case s.Truncated:
+		r.Status = "truncated"
+	}
+	return r
+}
+
+// writeReport writes the -report JSON for the run described by s to path
+func writeReport(path string, config *Config, s *runSummary, finished time.Time) error {
+	data, err := json.MarshalIndent(newRunReport(config, s, finished), "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding report: %v", err)
+	}
+	if err := replaceFile(path, append(data, '\n')); err != nil {
+		return fmt.Errorf("error writing report file: %v", err)
+	}
+	return nil
+}
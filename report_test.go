@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// readReport decodes the -report file at path
+func readReport(t *testing.T, path string) runReport {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r runReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		t.Fatalf("invalid report: %v\n%s", err, data)
+	}
+	return r
+}
+
+func TestReport(t *testing.T) {
+	dir := t.TempDir()
+	report := filepath.Join(dir, "report.json")
+	if _, _, err := run(t, "-cidr", "10.0.0.0/28,10.0.0.8/30", "-exclude", "10.0.0.0/31", "-dedupe", "-format", "csv", "-checksum", "-output", dir, "-filename", "list", "-report", report); err != nil {
+		t.Fatal(err)
+	}
+	r := readReport(t, report)
+
+	// The counts and checksum match the file the run wrote
+	path := filepath.Join(dir, "list.csv")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if got := len(lines(string(data))) - 1; r.Count != got {
+		t.Errorf("count %d, but the file has %d rows", r.Count, got)
+	}
+	if r.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("sha256 %s doesn't match the file", r.SHA256)
+	}
+	if r.OutputFile != path {
+		t.Errorf("output_file %q, want %q", r.OutputFile, path)
+	}
+	if !slices.Equal(r.Inputs, []string{"10.0.0.0/28", "10.0.0.8/30"}) || r.Format != "csv" || r.Status != "completed" {
+		t.Errorf("inputs %q, format %q, status %q", r.Inputs, r.Format, r.Status)
+	}
+	if r.Expected != 20 || r.Count != 14 || r.Skipped.Excluded != 2 || r.Skipped.Duplicates != 4 {
+		t.Errorf("expected %d, count %d, excluded %d, duplicates %d", r.Expected, r.Count, r.Skipped.Excluded, r.Skipped.Duplicates)
+	}
+	if r.StartedAt.IsZero() || r.DurationMs < 0 {
+		t.Errorf("started_at %v, duration_ms %d", r.StartedAt, r.DurationMs)
+	}
+
+	// A limit cutting the run short is its status
+	if _, _, err := run(t, "-cidr", "10.0.0.0/28", "-limit", "3", "-stdout", "-report", report); err != nil {
+		t.Fatal(err)
+	}
+	if r := readReport(t, report); r.Status != "truncated" || r.Count != 3 || r.OutputFile != "stdout" || r.SHA256 != "" {
+		t.Errorf("status %q, count %d, output_file %q, sha256 %q", r.Status, r.Count, r.OutputFile, r.SHA256)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"time"
+)
+
+// lookup is one pending reverse DNS query, delivering the hostname (empty
+// if there is none) on done
+type lookup struct {
+	ip   string      // Address being resolved
+	done chan string // Receives the hostname once the query finishes
+}
+
+// resolveFormatter writes each address followed by a tab and its reverse
+// DNS hostname. Up to workers lookups run at once; results are written in
+// the order the addresses arrived, so the output stays ascending.
+type resolveFormatter struct {
+	workers int           // Maximum lookups in flight
+	timeout time.Duration // Bound on each lookup
+	pending []lookup      // Lookups not yet written, oldest first
+}
+
+func (f *resolveFormatter) begin(w *bufio.Writer) error { return nil }
+
+func (f *resolveFormatter) writeIP(w *bufio.Writer, ip net.IP) error {
+	l := lookup{ip: ip.String(), done: make(chan string, 1)}
+	go func() {
+		l.done <- f.resolve(l.ip)
+	}()
+	f.pending = append(f.pending, l)
+
+	// Once the pool is full, wait for the oldest lookup before starting more
+	if len(f.pending) >= f.workers {
+		return f.flush(w, 1)
+	}
+	return nil
+}
+
+func (f *resolveFormatter) end(w *bufio.Writer) error {
+	return f.flush(w, len(f.pending))
+}
+
+// flush waits for the n oldest pending lookups and writes their lines
+func (f *resolveFormatter) flush(w *bufio.Writer, n int) error {
+	for _, l := range f.pending[:n] {
+		if _, err := w.WriteString(l.ip + "\t" + <-l.done + "\n"); err != nil {
+			return err
+		}
+	}
+	f.pending = f.pending[n:]
+	return nil
+}
+
+// resolve returns the first reverse DNS name for ip without the trailing
+// dot, or an empty string if the lookup fails or times out
+func (f *resolveFormatter) resolve(ip string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), f.timeout)
+	defer cancel()
+
+	names, err := net.DefaultResolver.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(names[0], ".")
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// fileSummary is the number of addresses written to one output file
+type fileSummary struct {
+	Path  string `json:"path"`
+	Count int    `json:"count"`
+}
+
+// rotatedPath returns path with file number n inserted before the format
+// extension, e.g. list.002.txt or list.002.csv.gz
+func rotatedPath(config *Config, path string, n int) string {
+	ext := formatExtensions[config.format]
+	if config.gzip {
+		ext += ".gz"
+	}
+	return fmt.Sprintf("%s.%03d%s", strings.TrimSuffix(path, ext), n, ext)
+}
+
+// rotate finishes the current file under -max-lines and continues in the
+// next numbered one. The finished file is flushed and closed before the
+// next is opened, and each file is a complete document in its format.
+func (g *generator) rotate() error {
+	if err := g.end(); err != nil {
+		return err
+	}
+
+	path := rotatedPath(g.config, g.basePath, len(g.rotated)+1)
+	if err := checkOverwrite(g.config, path); err != nil {
+		return err
+	}
+	out, err := openOutput(g.config, path, nil)
+	if err != nil {
+		return err
+	}
+	return g.begin(out)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// writeShuffledHosts writes span, part of target i, one 256-address block
+// at a time (a /24 for IPv4, a /120 for IPv6) with the hosts of each block
+// in random order. The blocks themselves keep their order, descending with
+// -reverse, and only the current one is held in memory.
+func (g *generator) writeShuffledHosts(span iplist.Range, i int) error {
+	block := make([]net.IP, 0, 256)
+	flush := func() error {
+		g.rng.Shuffle(len(block), func(a, b int) { block[a], block[b] = block[b], block[a] })
+		for _, ip := range block {
+			if err := g.emit(ip, i); err != nil {
+				return err
+			}
+		}
+		block = block[:0]
+		return nil
+	}
+
+	collect := func(ip net.IP) error {
+		// Addresses of one block share everything but the last byte
+		if len(block) > 0 && !bytes.Equal(ip[:len(ip)-1], block[0][:len(ip)-1]) {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		block = append(block, ip)
+		return nil
+	}
+
+	var err error
+	if g.config.reverse {
+		err = iplist.EnumerateRangeReverse(span, 1, collect)
+	} else {
+		err = iplist.EnumerateRange(span, collect)
+	}
+	if err != nil {
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"testing"
+)
+
+func TestShuffleHosts(t *testing.T) {
+	for _, args := range [][]string{
+		{"-cidr", "10.0.0.0/22"},
+		{"-cidr", "10.0.0.0/22", "-reverse"},
+		{"-range", "10.0.0.250-10.0.1.5"},
+		{"-cidr", "2001:db8::/119"},
+	} {
+		plain := lines(mustRun(t, append(args, "-stdout")...))
+		shuffled := lines(mustRun(t, append(args, "-stdout", "-shuffle-hosts", "-seed", "42")...))
+		if len(shuffled) != len(plain) {
+			t.Fatalf("%v: got %d IPs, want %d", args, len(shuffled), len(plain))
+		}
+
+		// Compare block by block, each block ending where the plain
+		// output moves on to the next /24 or /120
+		reordered := false
+		for start := 0; start < len(plain); {
+			end := start + 1
+			for end < len(plain) && block(plain[end]) == block(plain[start]) {
+				end++
+			}
+			want := slices.Clone(plain[start:end])
+			got := slices.Clone(shuffled[start:end])
+			if !slices.Equal(got, want) {
+				reordered = true
+			}
+			slices.Sort(want)
+			slices.Sort(got)
+			if !slices.Equal(got, want) {
+				t.Errorf("%v: block of %s doesn't hold the same hosts", args, plain[start])
+			}
+			start = end
+		}
+		if !reordered {
+			t.Errorf("%v: no block was shuffled", args)
+		}
+	}
+}
+
+// block returns all but the last byte of ip, which every address of its
+// /24 or /120 shares
+func block(ip string) string {
+	parsed := net.ParseIP(ip).To16()
+	return string(parsed[:len(parsed)-1])
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// sortTargets merges targets into disjoint ranges in ascending order, IPv4
+// before IPv6, joining any that overlap or touch. Enumerating the result
+// writes every address once in one globally ascending stream while only
+// the ranges are held, not the addresses. A merged range that is exactly
+// a CIDR network is kept as one, so it is still reported by its prefix.
+func sortTargets(targets []target) []target {
+	spans := make([]iplist.Range, len(targets))
+	for i, t := range targets {
+		spans[i] = t.span
+	}
+	sort.Slice(spans, func(a, b int) bool {
+		if spans[a].IsIPv4() != spans[b].IsIPv4() {
+			return spans[a].IsIPv4()
+		}
+		return bytes.Compare(spans[a].First, spans[b].First) < 0
+	})
+
+	var merged []iplist.Range
+	for _, span := range spans {
+		if n := len(merged); n > 0 && touches(merged[n-1], span) {
+			if bytes.Compare(span.Last, merged[n-1].Last) > 0 {
+				merged[n-1].Last = span.Last
+			}
+			continue
+		}
+		merged = append(merged, span)
+	}
+
+	sorted := make([]target, len(merged))
+	for i, span := range merged {
+		sorted[i] = target{span: span}
+		if cidrs := span.CIDRs(); len(cidrs) == 1 {
+			sorted[i].ipnet = cidrs[0]
+		}
+	}
+	return sorted
+}
+
+// touches reports whether next, which starts no earlier than prev, overlaps
+// prev or begins right after it, in the same family
+func touches(prev, next iplist.Range) bool {
+	if prev.IsIPv4() != next.IsIPv4() {
+		return false
+	}
+	if bytes.Compare(next.First, prev.Last) <= 0 {
+		return true
+	}
+	// The address after the top of the space wraps to zero, which can't be
+	// the start of a later range
+	return iplist.NextIP(prev.Last).Equal(next.First)
+}
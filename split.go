@@ -0,0 +1,162 @@
+package main
+
+import (
+	"net"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// maxParallelFiles caps -parallel-files, keeping the files open at once
+// well below common descriptor limits
+const maxParallelFiles = 64
+
+// writeSplit writes every prefix-sized subnet of each target to its own
+// file in the output directory, or to its own entry of the -archive, each
+// a complete document in the output format
+func (g *generator) writeSplit(prefix int) error {
+	if g.config.splitJobs > 1 {
+		return g.writeSplitParallel(prefix, g.config.splitJobs)
+	}
+	for i, t := range g.targets {
+		g.progress.enter(i)
+		err := iplist.EnumerateSubnets(t.ipnet, prefix, func(subnet *net.IPNet) error {
+			return g.writeSubnet(subnet, i)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSubnet writes subnet, part of target i, to its own file or archive
+// entry
+func (g *generator) writeSubnet(subnet *net.IPNet, i int) error {
+	name := splitFilename(g.config, subnet)
+	var out *output
+	var err error
+	if g.archive != nil {
+		out, err = openOutput(g.config, "", &g.archive.buf)
+	} else {
+		path := filepath.Join(g.config.outputDir, name)
+		if err := checkOverwrite(g.config, path); err != nil {
+			return err
+		}
+		out, err = openOutput(g.config, path, nil)
+	}
+	if err != nil {
+		return err
+	}
+	defer out.discard()
+	if err := g.begin(out); err != nil {
+		return err
+	}
+
+	// A limit or cancellation ends the whole run, but the current file is
+	// still completed so it stays valid
+	err = g.writeRange(iplist.NetworkRange(subnet), i)
+	if err != nil && !stopped(err) {
+		return err
+	}
+	if endErr := g.end(); endErr != nil {
+		return endErr
+	}
+	if g.archive != nil {
+		if addErr := g.archive.add(name); addErr != nil {
+			return addErr
+		}
+	}
+	g.files++
+	return err
+}
+
+// writeSplitParallel is writeSplit with up to n subnet files written at
+// once. Each worker writes its files with its own copy of the generator,
+// with its own output, formatter and counts, which are added to g's once
+// every worker is done. The first failure stops the files not yet started;
+// one that stops the run, such as a cancellation, is reported only if
+// nothing actually went wrong.
+func (g *generator) writeSplitParallel(prefix, n int) error {
+	type job struct {
+		subnet *net.IPNet
+		i      int
+	}
+	jobs := make(chan job)
+
+	var mu sync.Mutex
+	var firstErr error
+	var failed atomic.Bool
+	var wg sync.WaitGroup
+	forks := make([]*generator, n)
+	for w := range forks {
+		forks[w] = g.fork()
+		wg.Add(1)
+		go func(fork *generator) {
+			defer wg.Done()
+			for j := range jobs {
+				if failed.Load() {
+					continue
+				}
+				if err := fork.writeSubnet(j.subnet, j.i); err != nil {
+					mu.Lock()
+					if firstErr == nil || stopped(firstErr) && !stopped(err) {
+						firstErr = err
+					}
+					mu.Unlock()
+					failed.Store(true)
+				}
+			}
+		}(forks[w])
+	}
+
+	var err error
+	for i, t := range g.targets {
+		g.progress.enter(i)
+		err = iplist.EnumerateSubnets(t.ipnet, prefix, func(subnet *net.IPNet) error {
+			if failed.Load() {
+				return errAborted
+			}
+			select {
+			case jobs <- job{subnet, i}:
+				return nil
+			case <-g.ctx.Done():
+				return g.ctx.Err()
+			}
+		})
+		if err != nil {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	for _, fork := range forks {
+		g.tally.add(fork.tally)
+		for k, count := range fork.perTarget {
+			g.perTarget[k] += count
+		}
+		g.files += fork.files
+	}
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return err
+}
+
+// fork returns a copy of g for writing one file alongside others. Only the
+// progress, which is safe for concurrent use, is shared; the filters are
+// copied because writeAround changes them while it runs.
+func (g *generator) fork() *generator {
+	fork := *g
+	filters := *g.filters
+	fork.filters = &filters
+	fork.tally = tally{}
+	fork.perTarget = make([]int, len(g.targets))
+	fork.visited = 0
+	fork.files = 0
+	fork.out, fork.writer, fork.formatter = nil, nil, nil
+	return &fork
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// readDir returns the contents of every file in dir by name
+func readDir(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string][]byte, len(entries))
+	for _, e := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[e.Name()] = data
+	}
+	return files
+}
+
+func TestParallelSplitMatchesSerial(t *testing.T) {
+	for _, args := range [][]string{
+		{"-cidr", "10.0.0.0/20", "-split", "/24"},
+		{"-cidr", "10.0.0.0/22", "-split", "/26", "-format", "csv"},
+		{"-cidr", "10.0.0.0/22", "-split", "/24", "-format", "json", "-usable"},
+		{"-cidr", "10.0.0.0/23,10.1.0.0/24", "-split", "/25", "-format", "hex", "-exclude", "10.0.0.64/26"},
+		{"-cidr", "2001:db8::/116", "-split", "/120", "-gzip"},
+	} {
+		serial, parallel := t.TempDir(), t.TempDir()
+		serialSummary, _, err := run(t, append(args, "-output", serial)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parallelSummary, _, err := run(t, append(args, "-output", parallel, "-parallel-files", "8")...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want, got := readDir(t, serial), readDir(t, parallel)
+		if len(got) != len(want) {
+			t.Errorf("%v: %d files in parallel, %d serially", args, len(got), len(want))
+		}
+		for name, data := range want {
+			if !bytes.Equal(got[name], data) {
+				t.Errorf("%v: %s differs from the serial run", args, name)
+			}
+		}
+
+		// The summaries agree on everything but the timing
+		same := func(summary string) []string {
+			return slices.DeleteFunc(lines(summary), func(line string) bool {
+				return hasAnyPrefix(line, "Time Taken:", "Average Speed:", "Output Directory:")
+			})
+		}
+		if !slices.Equal(same(parallelSummary), same(serialSummary)) {
+			t.Errorf("%v: summaries differ:\n%s\n%s", args, parallelSummary, serialSummary)
+		}
+	}
+}
+
+// hasAnyPrefix reports whether s starts with any of prefixes
+func hasAnyPrefix(s string, prefixes ...string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
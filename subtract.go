@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// subtractCIDRs writes the fewest CIDR networks covering the targets minus
+// the -subtract networks to w, or to the file -output or -filename names,
+// one per line, without enumerating any addresses. Every subtracted network
+// must lie inside one of the targets, which catches a mistyped exclusion
+// that would otherwise remove nothing.
+func subtractCIDRs(config *Config, targets []target, w io.Writer) error {
+	excludes, _, err := parseCIDRList(config.subtract)
+	if err != nil {
+		return fmt.Errorf("invalid -subtract: %v", err)
+	}
+
+	// Start-end ranges take part as the networks that cover them
+	var bases []*net.IPNet
+	for _, t := range targets {
+		if t.ipnet != nil {
+			bases = append(bases, t.ipnet)
+		} else {
+			bases = append(bases, t.span.CIDRs()...)
+		}
+	}
+
+	for _, ex := range excludes {
+		if !insideAny(ex, bases) {
+			return fmt.Errorf("-subtract %s is not inside any of the base ranges", ex)
+		}
+	}
+
+	out, err := openListOutput(config, w)
+	if err != nil {
+		return err
+	}
+	for _, base := range bases {
+		for _, ipnet := range iplist.Subtract(base, excludes) {
+			if _, err := out.writer.WriteString(ipnet.String() + "\n"); err != nil {
+				out.discard()
+				return writeError(err)
+			}
+		}
+	}
+	return out.close()
+}
+
+// insideAny reports whether every address of ipnet falls inside one of the
+// given networks
+func insideAny(ipnet *net.IPNet, networks []*net.IPNet) bool {
+	ones, bits := ipnet.Mask.Size()
+	for _, n := range networks {
+		nOnes, nBits := n.Mask.Size()
+		if nBits == bits && nOnes <= ones && n.Contains(ipnet.IP) {
+			return true
+		}
+	}
+	return false
+}
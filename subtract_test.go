@@ -0,0 +1,38 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSubtract(t *testing.T) {
+	got := mustRun(t, "-cidr", "10.0.0.0/24", "-subtract", "10.0.0.0/26,10.0.0.192/27")
+	equalLines(t, got, []string{"10.0.0.64/26", "10.0.0.128/26", "10.0.0.224/27"})
+
+	// A network outside every base range is rejected
+	if _, _, err := run(t, "-cidr", "10.0.0.0/24", "-subtract", "10.0.1.0/26"); err == nil {
+		t.Error("expected an error for a -subtract network outside the ranges")
+	}
+}
+
+func TestSubtractOutput(t *testing.T) {
+	dir := t.TempDir()
+	stdout, _, err := run(t, "-cidr", "10.0.0.0/24", "-subtract", "10.0.0.0/26", "-output", dir, "-filename", "left")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stdout != "" {
+		t.Errorf("wrote %q to stdout with -output set", stdout)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "left.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	equalLines(t, string(data), []string{"10.0.0.64/26", "10.0.0.128/25"})
+
+	// The file isn't replaced without -overwrite
+	if _, _, err := run(t, "-cidr", "10.0.0.0/24", "-subtract", "10.0.0.0/26", "-output", dir, "-filename", "left"); err == nil {
+		t.Error("expected an error overwriting the -subtract output")
+	}
+}
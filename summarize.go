@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// summarizeIPs reads a list of addresses from the -summarize file, or from
+// stdin when it is "-", and writes the fewest CIDR networks covering them
+// to w, one per line. Blank lines and anything after a # are ignored, and
+// every unparseable line is reported with its line number.
+func summarizeIPs(config *Config, w io.Writer) error {
+	in := io.Reader(os.Stdin)
+	name := "stdin"
+	if config.summarize != "-" {
+		file, err := os.Open(config.summarize)
+		if err != nil {
+			return fmt.Errorf("error opening IP list: %v", err)
+		}
+		defer file.Close()
+		in = file
+		name = config.summarize
+	}
+
+	var ips []net.IP
+	var problems []string
+	scanner := bufio.NewScanner(in)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		ip := net.ParseIP(line)
+		if ip == nil {
+			problems = append(problems, fmt.Sprintf("  line %d: invalid IP address %q", lineNum, line))
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading IP list: %v", err)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid IP address in %s:\n%s", name, strings.Join(problems, "\n"))
+	}
+
+	out := bufio.NewWriter(w)
+	for _, ipnet := range iplist.Summarize(ips) {
+		if _, err := out.WriteString(ipnet.String() + "\n"); err != nil {
+			return writeError(err)
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return writeError(err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/kumarasakti/ip-list-generator/iplist"
+)
+
+// validateInputs checks the syntax of every -cidr, -cidr-file and -range
+// entry without generating anything, for linting inventory files in CI.
+// Unlike a normal run it keeps going past the first bad entry and reports
+// all of them, each with where it came from. A CIDR with host bits set is
+// noted, or counted as invalid under -strict.
+func validateInputs(config *Config, stdout, logOut io.Writer) error {
+	var problems []string
+	checked := 0
+	checkCIDR := func(where, entry string) {
+		checked++
+		ip, ipnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("  %s: %v", where, err))
+			return
+		}
+		if note := hostBitsNote(entry, ip, ipnet); note != "" {
+			if config.strict {
+				problems = append(problems, fmt.Sprintf("  %s: %s", where, note))
+				return
+			}
+			warn(logOut, config, "%s: %s", where, note)
+		}
+	}
+
+	if config.cidr != "" {
+		for n, entry := range strings.Split(config.cidr, ",") {
+			checkCIDR(fmt.Sprintf("-cidr entry %d", n+1), strings.TrimSpace(entry))
+		}
+	}
+
+	if config.cidrFile != "" {
+		file, err := os.Open(config.cidrFile)
+		if err != nil {
+			return fmt.Errorf("error opening CIDR file: %v", err)
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for lineNum := 1; scanner.Scan(); lineNum++ {
+			line := scanner.Text()
+			if i := strings.Index(line, "#"); i >= 0 {
+				line = line[:i]
+			}
+			if line = strings.TrimSpace(line); line != "" {
+				checkCIDR(fmt.Sprintf("%s line %d", config.cidrFile, lineNum), line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("error reading CIDR file: %v", err)
+		}
+	}
+
+	if config.ipRange != "" {
+		for n, entry := range strings.Split(config.ipRange, ",") {
+			checked++
+			if _, err := iplist.ParseRange(strings.TrimSpace(entry)); err != nil {
+				problems = append(problems, fmt.Sprintf("  -range entry %d: %v", n+1, err))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%d of %d entries are invalid:\n%s", len(problems), checked, strings.Join(problems, "\n"))
+	}
+	fmt.Fprintf(stdout, "All entries are valid (%d checked)\n", checked)
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateOnly(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "inventory.txt")
+	if err := os.WriteFile(path, []byte("10.0.0.0/24\nbogus # typo\n\n10.0.0.5/24\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "lists")
+
+	// Every bad entry is reported with where it came from, not just the
+	// first, and nothing is created
+	stdout, _, err := run(t, "-validate-only", "-cidr-file", path, "-cidr", "10.0.0.0/8,1.2.3/4", "-range", "10.0.0.9-10.0.0.1", "-output", output)
+	if err == nil {
+		t.Fatal("expected an error for invalid entries")
+	}
+	for _, want := range []string{"3 of 6 entries are invalid", "-cidr entry 2: ", path + " line 2: ", "-range entry 1: "} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error doesn't contain %q: %v", want, err)
+		}
+	}
+	if !strings.Contains(stdout, path+" line 4: 10.0.0.5/24 has host bits set") {
+		t.Errorf("no host bits warning:\n%s", stdout)
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("-validate-only created the output directory: %v", err)
+	}
+
+	// Host bits only count as invalid under -strict
+	stdout, _, err = run(t, "-validate-only", "-cidr", "10.0.0.0/8,10.0.0.5/24", "-output", output)
+	if err != nil || !strings.HasSuffix(stdout, "\nAll entries are valid (2 checked)\n") {
+		t.Errorf("got %q, %v", stdout, err)
+	}
+	if _, _, err := run(t, "-validate-only", "-cidr", "10.0.0.0/8,10.0.0.5/24", "-strict"); err == nil || !strings.Contains(err.Error(), "1 of 2 entries are invalid") {
+		t.Errorf("-strict: got %v", err)
+	}
+}